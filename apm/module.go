@@ -0,0 +1,47 @@
+package apm
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**  模块间rpc调用的追踪，实现了module.CallTracer
+  *  用法：module.SetCallTracer(apm.NewModuleTracer("module", false))
+  *  gin、grpc、kafka等请求的ctx通过Call0Context/GoContext等传入模块，handler中用CallContext取回
+**/
+
+const (
+	ModuleNameKey = attribute.Key("module.name")
+	ModuleCallKey = attribute.Key("module.call.id")
+)
+
+// ModuleTracer 每个消息id一个span
+type ModuleTracer struct {
+	tracer trace.Tracer
+	root   bool
+}
+
+// NewModuleTracer root为false时只追踪已经在链路中的调用，避免定时器、内部通知等产生大量没有意义的链路
+func NewModuleTracer(tracerName string, root bool) *ModuleTracer {
+	return &ModuleTracer{tracer: otel.Tracer(tracerName), root: root}
+}
+
+func (t *ModuleTracer) StartCall(ctx context.Context, module string, id any) (context.Context, func(error)) {
+	if !t.root && !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, func(error) {}
+	}
+	name := fmt.Sprintf("%s/%v", module, id)
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(ModuleNameKey.String(module), ModuleCallKey.String(fmt.Sprint(id))))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
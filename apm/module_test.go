@@ -0,0 +1,32 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestModuleTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	mt := &ModuleTracer{tracer: tp.Tracer("test")}
+
+	//不在链路中的调用不追踪
+	_, end := mt.StartCall(context.Background(), "m", "f")
+	end(nil)
+	assert.Empty(t, recorder.Ended())
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "gin")
+	_, end = mt.StartCall(ctx, "m", "f")
+	end(errors.New("boom"))
+	parent.End()
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "m/f", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
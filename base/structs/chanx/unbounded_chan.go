@@ -19,18 +19,18 @@ type UnboundedChan[T any] struct {
 // Len returns len of In plus len of Out plus len of buffer.
 // It is not accurate and only for your evaluating approximate number of elements in this chan,
 // see https://github.com/smallnest/chanx/issues/7.
-func (c UnboundedChan[T]) Len() int {
+func (c *UnboundedChan[T]) Len() int {
 	return len(c.In) + c.BufLen() + len(c.Out)
 }
 
 // BufLen returns len of the buffer.
 // It is not accurate and only for your evaluating approximate number of elements in this chan,
 // see https://github.com/smallnest/chanx/issues/7.
-func (c UnboundedChan[T]) BufLen() int {
+func (c *UnboundedChan[T]) BufLen() int {
 	return int(atomic.LoadInt64(&c.bufCount))
 }
 
-func (c UnboundedChan[T]) Close() {
+func (c *UnboundedChan[T]) Close() {
 	if c.isClosed {
 		return
 	}
@@ -38,7 +38,7 @@ func (c UnboundedChan[T]) Close() {
 	c.isClosed = true
 }
 
-func (c UnboundedChan[T]) IsClosed() bool {
+func (c *UnboundedChan[T]) IsClosed() bool {
 	return c.isClosed
 }
 
@@ -0,0 +1,18 @@
+package ginutil
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const ModuleAdminPrefix = "/admin/modules"
+
+// EnableModuleAdmin 注册模块管理接口，使用module时传入server.AdminHandler()
+// prefix为空时使用ModuleAdminPrefix，注意不要暴露在公网
+func EnableModuleAdmin(r *gin.Engine, admin http.Handler, prefix ...string) {
+	p := ModuleAdminPrefix
+	if len(prefix) > 0 && prefix[0] != "" {
+		p = prefix[0]
+	}
+	r.Any(p+"/*action", gin.WrapH(admin))
+}
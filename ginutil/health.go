@@ -0,0 +1,18 @@
+package ginutil
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// EnableHealthCheck 注册k8s的liveness和readiness探针
+// 使用module时可以传入server.LivenessHandler()和server.ReadinessHandler()
+func EnableHealthCheck(r *gin.Engine, liveness http.Handler, readiness http.Handler) {
+	r.GET(LivenessPath, gin.WrapH(liveness))
+	r.GET(ReadinessPath, gin.WrapH(readiness))
+}
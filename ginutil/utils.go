@@ -14,7 +14,7 @@ import (
 func InitRouter(allowHeaders ...string) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	logHandler := AccessLogHandler(false, true, "/metrics")
+	logHandler := AccessLogHandler(false, true, "/metrics", LivenessPath, ReadinessPath)
 	router.Use(logHandler)
 	router.Use(RecoveryHandler())
 	router.Use(CorsHandler(allowHeaders...))
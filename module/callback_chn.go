@@ -5,12 +5,13 @@ import (
 	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/base/structs/chanx"
 	"sync"
+	"sync/atomic"
 )
 
 //CallbackChn 是回调函数的队列包装
 type CallbackChn struct {
 	ChanCb    *chanx.UnboundedChan[func()]
-	pendingGo int
+	pendingGo int32 //监控会在其他协程读取，需要原子操作
 }
 
 // LinearCallbackChn 包括一个待执行函数和执行完毕之后回调的函数
@@ -33,7 +34,7 @@ func NewCallbackChn() *CallbackChn {
 }
 
 func (g *CallbackChn) Go(f func(), cb func()) {
-	g.pendingGo++
+	atomic.AddInt32(&g.pendingGo, 1)
 
 	go func() {
 		defer func() {
@@ -48,7 +49,7 @@ func (g *CallbackChn) Go(f func(), cb func()) {
 
 func (g *CallbackChn) Cb(cb func()) {
 	defer func() {
-		g.pendingGo--
+		atomic.AddInt32(&g.pendingGo, -1)
 		if r := recover(); r != nil {
 			log.PanicStack("", r)
 		}
@@ -61,13 +62,13 @@ func (g *CallbackChn) Cb(cb func()) {
 
 // Close 关闭之前需要执行完所有回调
 func (g *CallbackChn) Close() {
-	for g.pendingGo > 0 {
+	for atomic.LoadInt32(&g.pendingGo) > 0 {
 		g.Cb(<-g.ChanCb.Out)
 	}
 }
 
 func (g *CallbackChn) Idle() bool {
-	return g.pendingGo == 0
+	return atomic.LoadInt32(&g.pendingGo) == 0
 }
 
 func (g *CallbackChn) NewLinearContext() *LinearContext {
@@ -78,7 +79,7 @@ func (g *CallbackChn) NewLinearContext() *LinearContext {
 }
 
 func (c *LinearContext) Go(f func(), cb func()) {
-	c.g.pendingGo++
+	atomic.AddInt32(&c.g.pendingGo, 1)
 
 	c.mutexLinearGo.Lock()
 	c.linearGo.PushBack(&LinearCallbackChn{f: f, cb: cb})
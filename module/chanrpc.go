@@ -1,14 +1,20 @@
 package module
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/base/structs/chanx"
+	"sync"
+	"sync/atomic"
 )
 
 const initBufferSize = 2048
 
+// ErrServerDraining 模块正在关闭，不再接收新的请求
+var ErrServerDraining = errors.New("chanrpc rpcServer draining")
+
 // RpcServer one rpcServer per goroutine (goroutine not safe)
 // one rpcClient per goroutine (goroutine not safe)
 type RpcServer struct {
@@ -19,15 +25,48 @@ type RpcServer struct {
 	// func(args []any) any
 	// func(args []any) []any
 	functions map[any]any
-	ChanCall  *chanx.UnboundedChan[*callInfo]
+	// ChanCall 普通优先级的请求队列
+	ChanCall *chanx.UnboundedChan[*callInfo]
+	draining int32
+
+	// 优先级，见priority.go
+	priorities map[any]Priority
+	chanHigh   *chanx.UnboundedChan[*callInfo]
+	chanLow    *chanx.UnboundedChan[*callInfo]
+
+	// 邮箱，见mailbox.go
+	queued         int64
+	rejected       int64
+	shed           int64
+	aboveWatermark int32
+	mailbox        MailboxConfig
+	slots          chan struct{}
+
+	// panic的处理策略，见crash.go
+	guard *panicGuard
+	// 正在执行的消息的上下文，见trace.go
+	current context.Context
+
+	// Drain或者Close时执行的回调，例如取消事件总线的订阅
+	hookMu     sync.Mutex
+	hookSeq    uint64
+	closeHooks map[uint64]func()
+	hooksDone  bool
 }
 
 type callInfo struct {
+	id   any
 	f    any
 	args []any
+	// 调用方的上下文，调用方放弃之后不再执行，执行时作为handler的CallContext
+	ctx context.Context
 	//仅需往里面写入
 	chanRet chan<- *retInfo
 	cb      any
+	// 决定进入哪个队列
+	priority Priority
+	// 内部的投递任务，不受邮箱容量限制
+	unlimited bool
 }
 
 type retInfo struct {
@@ -43,17 +82,40 @@ type retInfo struct {
 	cb any
 }
 
+// CallTimeoutError 调用方等待超时或者主动取消
+type CallTimeoutError struct {
+	Id any
+	// context.DeadlineExceeded 或者 context.Canceled
+	Err error
+}
+
+func (e *CallTimeoutError) Error() string {
+	return fmt.Sprintf("function id %v: call abandoned, %v", e.Id, e.Err)
+}
+
+func (e *CallTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout 是否是超时，否则是被取消
+func (e *CallTimeoutError) Timeout() bool {
+	return errors.Is(e.Err, context.DeadlineExceeded)
+}
+
 type RpcClient struct {
 	s                *RpcServer
 	chanSyncRet      chan *retInfo
 	chanAsyncRet     *chanx.UnboundedChan[*retInfo]
-	pendingAsyncCall int
+	pendingAsyncCall int32
 }
 
 func NewRpcServer() *RpcServer {
 	s := new(RpcServer)
 	s.functions = make(map[any]any)
 	s.ChanCall = chanx.NewUnboundedChan[*callInfo](initBufferSize)
+	s.priorities = make(map[any]Priority)
+	s.chanHigh = chanx.NewUnboundedChan[*callInfo](initBufferSize)
+	s.chanLow = chanx.NewUnboundedChan[*callInfo](initBufferSize)
 	return s
 }
 
@@ -99,13 +161,27 @@ func (s *RpcServer) ret(ci *callInfo, ri *retInfo) (err error) {
 }
 
 func (s *RpcServer) exec(ci *callInfo) (err error) {
+	s.dequeue(ci)
+	ctx, end := s.startCall(ci)
+	s.current = ctx
 	defer func() {
+		s.current = nil
 		if r := recover(); r != nil {
-			log.PanicStack("", r)
-			_ = s.ret(ci, &retInfo{err: fmt.Errorf("%v", r)})
+			report := s.guard.recovered(ci.id, ci.args, r)
+			perr := fmt.Errorf("%v", r)
+			_ = s.ret(ci, &retInfo{err: perr})
+			end(perr)
+			report.escalate()
+			return
 		}
+		end(nil)
 	}()
 
+	// 调用方已经放弃了，没必要再执行
+	if ci.ctx != nil && ci.ctx.Err() != nil {
+		return s.ret(ci, &retInfo{err: &CallTimeoutError{Id: ci.id, Err: ci.ctx.Err()}})
+	}
+
 	// execute
 	switch ci.f.(type) {
 	case func([]any):
@@ -128,20 +204,81 @@ func (s *RpcServer) execIgnoreError(ci *callInfo) {
 	}
 }
 
+// Drain 停止接收新的请求，已经在队列中的请求会继续执行，goroutine safe
+func (s *RpcServer) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+	s.runCloseHooks()
+}
+
+// addCloseHook 注册Drain或者Close时执行的回调，已经执行过时立即执行f
+// 返回取消注册的函数
+func (s *RpcServer) addCloseHook(f func()) (remove func()) {
+	s.hookMu.Lock()
+	if s.hooksDone {
+		s.hookMu.Unlock()
+		f()
+		return func() {}
+	}
+	if s.closeHooks == nil {
+		s.closeHooks = make(map[uint64]func())
+	}
+	s.hookSeq++
+	id := s.hookSeq
+	s.closeHooks[id] = f
+	s.hookMu.Unlock()
+	return func() {
+		s.hookMu.Lock()
+		delete(s.closeHooks, id)
+		s.hookMu.Unlock()
+	}
+}
+
+// runCloseHooks 执行所有回调，只执行一次
+func (s *RpcServer) runCloseHooks() {
+	s.hookMu.Lock()
+	hooks := s.closeHooks
+	s.closeHooks = nil
+	s.hooksDone = true
+	s.hookMu.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+}
+
+// Draining 是否已经停止接收新的请求
+func (s *RpcServer) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
 // Go 在Server模块主线程里面运行命令，异步执行，goroutine safe
 func (s *RpcServer) Go(id any, args ...any) {
+	s.goWithContext(context.Background(), s.priorityOf(id), id, args)
+}
+
+func (s *RpcServer) goWithContext(ctx context.Context, p Priority, id any, args []any) {
 	f := s.functions[id]
 	if f == nil {
 		return
 	}
+	if s.Draining() {
+		log.Warn("function id %v: %v, drop", id, ErrServerDraining)
+		return
+	}
 
 	defer func() {
 		recover()
 	}()
 
-	s.ChanCall.In <- &callInfo{
-		f:    f,
-		args: args,
+	//ctx只用于入队时的等待，handler收到的ctx不会被取消
+	err := s.enqueue(ctx, &callInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		ctx:      detach(ctx),
+		priority: p,
+	})
+	if err != nil {
+		log.Warn("function id %v: %v, drop", id, err)
 	}
 }
 
@@ -160,12 +297,31 @@ func (s *RpcServer) CallN(id any, args ...any) ([]any, error) {
 	return s.CreateClient(true).CallN(id, args...)
 }
 
+// Call0Context 同步调用，无返回结果，ctx超时或取消时返回CallTimeoutError，goroutine safe
+func (s *RpcServer) Call0Context(ctx context.Context, id any, args ...any) error {
+	return s.CreateClient(true).Call0Context(ctx, id, args...)
+}
+
+// Call1Context 同步调用，单个返回结果，ctx超时或取消时返回CallTimeoutError，goroutine safe
+func (s *RpcServer) Call1Context(ctx context.Context, id any, args ...any) (any, error) {
+	return s.CreateClient(true).Call1Context(ctx, id, args...)
+}
+
+// CallNContext 同步调用，返回数组，ctx超时或取消时返回CallTimeoutError，goroutine safe
+func (s *RpcServer) CallNContext(ctx context.Context, id any, args ...any) ([]any, error) {
+	return s.CreateClient(true).CallNContext(ctx, id, args...)
+}
+
 func (s *RpcServer) Close() {
-	s.ChanCall.Close()
-	for ci := range s.ChanCall.Out {
-		_ = s.ret(ci, &retInfo{
-			err: errors.New("chanrpc rpcServer closed"),
-		})
+	s.runCloseHooks()
+	for _, ch := range []*chanx.UnboundedChan[*callInfo]{s.chanHigh, s.ChanCall, s.chanLow} {
+		ch.Close()
+		for ci := range ch.Out {
+			s.dequeue(ci)
+			_ = s.ret(ci, &retInfo{
+				err: errors.New("chanrpc rpcServer closed"),
+			})
+		}
 	}
 }
 
@@ -191,15 +347,14 @@ func (c *RpcClient) Attach(s *RpcServer) {
 	c.s = s
 }
 
-func (c *RpcClient) call(ci *callInfo) (err error) {
+func (c *RpcClient) call(ctx context.Context, ci *callInfo) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = r.(error)
 		}
 	}()
 	//阻塞
-	c.s.ChanCall.In <- ci
-	return
+	return c.s.enqueue(ctx, ci)
 }
 
 func (c *RpcClient) f(id any, n int) (f any, err error) {
@@ -207,6 +362,10 @@ func (c *RpcClient) f(id any, n int) (f any, err error) {
 		err = errors.New("rpcServer not attached")
 		return
 	}
+	if c.s.Draining() {
+		err = ErrServerDraining
+		return
+	}
 
 	f = c.s.functions[id]
 	if f == nil {
@@ -232,74 +391,85 @@ func (c *RpcClient) f(id any, n int) (f any, err error) {
 	return
 }
 
-func (c *RpcClient) Call0(id any, args ...any) error {
-	f, err := c.f(id, 0)
+func (c *RpcClient) syncCall(ctx context.Context, id any, args []any, n int) (*retInfo, error) {
+	f, err := c.f(id, n)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = c.call(&callInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
+	err = c.call(ctx, &callInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		ctx:      ctx,
+		chanRet:  c.chanSyncRet,
+		priority: c.s.priorityOf(id),
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	select {
+	case ri := <-c.chanSyncRet:
+		return ri, ri.err
+	case <-ctx.Done():
+		//结果可能稍后才写入，换一个新的chan，避免下次调用读到旧的结果
+		c.chanSyncRet = make(chan *retInfo, 1)
+		return nil, &CallTimeoutError{Id: id, Err: ctx.Err()}
 	}
+}
 
-	ri := <-c.chanSyncRet
-	return ri.err
+func (c *RpcClient) Call0(id any, args ...any) error {
+	return c.Call0Context(context.Background(), id, args...)
 }
 
 func (c *RpcClient) Call1(id any, args ...any) (any, error) {
-	f, err := c.f(id, 1)
-	if err != nil {
-		return nil, err
-	}
+	return c.Call1Context(context.Background(), id, args...)
+}
 
-	err = c.call(&callInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	})
-	if err != nil {
-		return nil, err
-	}
+func (c *RpcClient) CallN(id any, args ...any) ([]any, error) {
+	return c.CallNContext(context.Background(), id, args...)
+}
 
-	ri := <-c.chanSyncRet
-	return ri.ret, ri.err
+// Call0Context 同步调用，ctx超时或取消时不再等待结果
+func (c *RpcClient) Call0Context(ctx context.Context, id any, args ...any) error {
+	_, err := c.syncCall(ctx, id, args, 0)
+	return err
 }
 
-func (c *RpcClient) CallN(id any, args ...any) ([]any, error) {
-	f, err := c.f(id, 2)
-	if err != nil {
+// Call1Context 同步调用，ctx超时或取消时不再等待结果
+func (c *RpcClient) Call1Context(ctx context.Context, id any, args ...any) (any, error) {
+	ri, err := c.syncCall(ctx, id, args, 1)
+	if ri == nil {
 		return nil, err
 	}
+	return ri.ret, err
+}
 
-	err = c.call(&callInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	})
-	if err != nil {
+// CallNContext 同步调用，ctx超时或取消时不再等待结果
+func (c *RpcClient) CallNContext(ctx context.Context, id any, args ...any) ([]any, error) {
+	ri, err := c.syncCall(ctx, id, args, 2)
+	if ri == nil {
 		return nil, err
 	}
-
-	ri := <-c.chanSyncRet
-	return assert(ri.ret), ri.err
+	return assert(ri.ret), err
 }
-func (c *RpcClient) asyncCall(id any, args []any, cb any, n int) {
+
+func (c *RpcClient) asyncCall(ctx context.Context, id any, args []any, cb any, n int) {
 	f, err := c.f(id, n)
 	if err != nil {
 		c.chanAsyncRet.In <- &retInfo{err: err, cb: cb}
 		return
 	}
 
-	err = c.call(&callInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanAsyncRet.In,
-		cb:      cb,
+	err = c.call(ctx, &callInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		ctx:      ctx,
+		chanRet:  c.chanAsyncRet.In,
+		cb:       cb,
+		priority: c.s.priorityOf(id),
 	})
 	if err != nil {
 		c.chanAsyncRet.In <- &retInfo{err: err, cb: cb}
@@ -308,6 +478,10 @@ func (c *RpcClient) asyncCall(id any, args []any, cb any, n int) {
 }
 
 func (c *RpcClient) AsyncCall(id any, _args ...any) {
+	c.asyncCallContext(context.Background(), id, _args)
+}
+
+func (c *RpcClient) asyncCallContext(ctx context.Context, id any, _args []any) {
 	if len(_args) < 1 {
 		panic("callback function not found")
 	}
@@ -326,8 +500,8 @@ func (c *RpcClient) AsyncCall(id any, _args ...any) {
 	default:
 		panic("definition of callback function is invalid")
 	}
-	c.asyncCall(id, args, cb, n)
-	c.pendingAsyncCall++
+	c.asyncCall(ctx, id, args, cb, n)
+	atomic.AddInt32(&c.pendingAsyncCall, 1)
 }
 
 func execCb(ri *retInfo) {
@@ -352,16 +526,16 @@ func execCb(ri *retInfo) {
 }
 
 func (c *RpcClient) cb(ri *retInfo) {
-	c.pendingAsyncCall--
+	atomic.AddInt32(&c.pendingAsyncCall, -1)
 	execCb(ri)
 }
 
 func (c *RpcClient) Close() {
-	for c.pendingAsyncCall > 0 {
+	for atomic.LoadInt32(&c.pendingAsyncCall) > 0 {
 		c.cb(<-c.chanAsyncRet.Out)
 	}
 }
 
 func (c *RpcClient) Idle() bool {
-	return c.pendingAsyncCall == 0
+	return atomic.LoadInt32(&c.pendingAsyncCall) == 0
 }
@@ -1,9 +1,12 @@
 package module
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 )
 
 //未导出测试
@@ -126,3 +129,55 @@ func TestRpcClient_Cb(t *testing.T) {
 	// 1 2 3
 	// 3
 }
+
+func TestRpcServer_CallContext(t *testing.T) {
+	s := NewRpcServer()
+	block := make(chan struct{})
+	executed := 0
+	s.Register("slow", func(args []any) {
+		<-block
+	})
+	s.Register("f1", func(args []any) any {
+		executed++
+		return 1
+	})
+	go func() {
+		for ci := range s.ChanCall.Out {
+			s.execIgnoreError(ci)
+		}
+	}()
+
+	s.Go("slow")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.Call1Context(ctx, "f1")
+	var te *CallTimeoutError
+	if !errors.As(err, &te) || !te.Timeout() || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect timeout error, got %v", err)
+	}
+	close(block)
+	//放弃的调用不会再执行
+	r, err := s.Call1("f1")
+	if err != nil || r != 1 || executed != 1 {
+		t.Fatalf("expect abandoned call skipped, got %v %v %d", r, err, executed)
+	}
+}
+
+func TestRpcServer_Drain(t *testing.T) {
+	g := NewGoroutineMixIn()
+	g.Register("f0", func(args []any) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+
+	if err := g.Call0("f0"); err != nil {
+		t.Fatal(err)
+	}
+	g.Drain()
+	if err := g.Call0("f0"); !errors.Is(err, ErrServerDraining) {
+		t.Fatalf("expect draining error, got %v", err)
+	}
+	if !g.Drained() {
+		t.Fatal("expect drained")
+	}
+}
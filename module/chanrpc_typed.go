@@ -0,0 +1,90 @@
+package module
+
+import (
+	"fmt"
+	"github.com/YiuTerran/go-common/base/structs/rpc"
+)
+
+/**  在RpcServer之上的强类型封装
+  *  参数和返回值在编译期检查，底层仍然走ChanCall
+  *  一个命令只有一个参数，多个参数请自行封装成struct
+**/
+
+// typedArg 取出唯一的参数并转换类型，类型不匹配时panic，会被RpcServer.exec捕获并返回给调用方
+func typedArg[Req any](id any, args []any) Req {
+	var req Req
+	if len(args) != 1 {
+		panic(fmt.Errorf("function id %v: expect 1 argument, got %d", id, len(args)))
+	}
+	if args[0] == nil {
+		return req
+	}
+	req, ok := args[0].(Req)
+	if !ok {
+		panic(fmt.Errorf("function id %v: argument type mismatch, expect %T, got %T", id, req, args[0]))
+	}
+	return req
+}
+
+// typedRet 将Call1的返回值转换成Resp
+func typedRet[Resp any](id any, ret any, err error) (Resp, error) {
+	var resp Resp
+	if err != nil || ret == nil {
+		return resp, err
+	}
+	resp, ok := ret.(Resp)
+	if !ok {
+		return resp, fmt.Errorf("function id %v: return type mismatch, expect %T, got %T", id, resp, ret)
+	}
+	return resp, nil
+}
+
+// RegisterTyped0 注册无返回值的强类型命令，可以用Go/Call0/GoTyped/Call0Typed调用
+func RegisterTyped0[Req any](s *RpcServer, id any, f func(Req)) {
+	s.Register(id, func(args []any) {
+		f(typedArg[Req](id, args))
+	})
+}
+
+// RegisterTyped 注册有返回值的强类型命令，可以用Call1/CallTyped/AsyncCallTyped调用
+func RegisterTyped[Req, Resp any](s *RpcServer, id any, f func(Req) Resp) {
+	s.Register(id, func(args []any) any {
+		return f(typedArg[Req](id, args))
+	})
+}
+
+// GoTyped 异步执行强类型命令，goroutine safe
+func GoTyped[Req any](s rpc.IServer, id any, req Req) {
+	s.Go(id, req)
+}
+
+// Call0Typed 同步执行无返回值的强类型命令，goroutine safe
+func Call0Typed[Req any](s rpc.IServer, id any, req Req) error {
+	return s.Call0(id, req)
+}
+
+// CallTyped 同步执行有返回值的强类型命令，goroutine safe
+func CallTyped[Req, Resp any](s rpc.IServer, id any, req Req) (Resp, error) {
+	ret, err := s.Call1(id, req)
+	return typedRet[Resp](id, ret, err)
+}
+
+// AsyncCallTyped 在g的协程中异步调用server的强类型命令，cb在g的协程中执行
+// server是本进程的RpcServer时走AsyncCall，否则(例如远程模块)在新的协程中调用Call1
+func AsyncCallTyped[Req, Resp any](g *GoroutineMixIn, server rpc.IServer, id any, req Req, cb func(Resp, error)) {
+	if s, ok := server.(*RpcServer); ok {
+		g.AsyncCall(s, id, req, func(ret any, err error) {
+			cb(typedRet[Resp](id, ret, err))
+		})
+		return
+	}
+	var (
+		ret any
+		err error
+	)
+	g.Go(func() {
+		ret, err = server.Call1(id, req)
+	}, func() {
+		cb(typedRet[Resp](id, ret, err))
+	})
+}
@@ -0,0 +1,78 @@
+package module
+
+import (
+	"context"
+	"github.com/YiuTerran/go-common/base/structs/rpc"
+	"testing"
+	"time"
+)
+
+type addReq struct {
+	a, b int
+}
+
+func TestCallTyped(t *testing.T) {
+	g := NewGoroutineMixIn()
+	RegisterTyped(g.RpcServer, "add", func(req *addReq) int {
+		return req.a + req.b
+	})
+	RegisterTyped0(g.RpcServer, "noop", func(req string) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+
+	r, err := CallTyped[*addReq, int](g.RPC(), "add", &addReq{1, 2})
+	if err != nil || r != 3 {
+		t.Fatalf("expect 3, got %v, %v", r, err)
+	}
+	if err = Call0Typed(g.RPC(), "noop", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	//类型不匹配时返回错误而不是让模块崩溃
+	if _, err = CallTyped[string, int](g.RPC(), "add", "1+2"); err == nil {
+		t.Fatal("expect argument type mismatch")
+	}
+	if _, err = CallTyped[*addReq, string](g.RPC(), "add", &addReq{}); err == nil {
+		t.Fatal("expect return type mismatch")
+	}
+}
+
+// remoteServer 模拟远程模块，只实现rpc.IServer
+type remoteServer struct {
+	*RpcServer
+}
+
+func TestAsyncCallTyped(t *testing.T) {
+	server := NewGoroutineMixIn()
+	RegisterTyped(server.RpcServer, "add", func(req *addReq) int {
+		return req.a + req.b
+	})
+	client := NewGoroutineMixIn()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	go client.Run(ctx)
+
+	results := make(chan int, 2)
+	for _, s := range []rpc.IServer{server.RpcServer, remoteServer{server.RpcServer}} {
+		s := s
+		client.Go(func() {}, func() {
+			AsyncCallTyped(client, s, "add", &addReq{1, 2}, func(r int, err error) {
+				if err != nil {
+					t.Error(err)
+				}
+				results <- r
+			})
+		})
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-results:
+			if r != 3 {
+				t.Fatalf("expect 3, got %d", r)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("async call timeout")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package module
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/**  可替换的时钟，用于测试定时器相关的逻辑
+**/
+
+// ClockTimer 时钟创建的定时器，*time.Timer也实现了这个接口
+type ClockTimer interface {
+	Stop() bool
+}
+
+// Clock 时钟，Dispatcher通过它获取当前时间和创建定时器
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// RealClock 系统时钟，默认使用
+var RealClock Clock = realClock{}
+
+// MockClock 虚拟时钟，只有调用Advance时时间才会前进
+// 与GoroutineMixIn配合使用时，Advance会等待每个到期的回调在模块协程中执行完毕，所以模块协程必须在运行
+// 模块协程退出之后Advance不再等待，到期的回调也不会执行
+type MockClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	timers []*mockTimer
+}
+
+type mockTimer struct {
+	c        *MockClock
+	deadline time.Time
+	seq      int //deadline相同时按创建顺序触发
+	f        func()
+}
+
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc d<=0的定时器会在下一次Advance(包括Advance(0))时触发
+func (c *MockClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &mockTimer{c: c, deadline: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	sort.Slice(c.timers, func(i, j int) bool {
+		if c.timers[i].deadline.Equal(c.timers[j].deadline) {
+			return c.timers[i].seq < c.timers[j].seq
+		}
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	return t
+}
+
+// Advance 时间前进d，按到期时间依次触发定时器
+// 回调中新建的定时器如果在d之内到期，也会被触发
+func (c *MockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		if len(c.timers) == 0 || c.timers[0].deadline.After(target) {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.deadline.After(c.now) {
+			c.now = t.deadline
+		}
+		c.mu.Unlock()
+		t.f()
+	}
+}
+
+// Pending 还没有触发的定时器数量
+func (c *MockClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *mockTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, x := range t.c.timers {
+		if x == t {
+			t.c.timers = append(t.c.timers[:i], t.c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
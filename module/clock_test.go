@@ -0,0 +1,67 @@
+package module
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMockClock(t *testing.T) {
+	clock := NewMockClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	g := NewGoroutineMixInWithClock(clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+
+	var fired []string
+	record := func(name string) func() {
+		return func() {
+			fired = append(fired, name+"@"+g.Clock().Now().Format("15:04:05"))
+		}
+	}
+	expr, _ := NewCronExpr("@every 1m")
+	g.AfterFunc(90*time.Second, record("after"))
+	stopped := g.AfterFunc(30*time.Second, record("stopped"))
+	cron := g.CronFunc(expr, record("cron"))
+	g.AfterFunc(10*time.Second, func() {
+		//回调中新建的定时器在Advance的范围内也会触发
+		g.AfterFunc(5*time.Second, record("nested"))
+	})
+	stopped.Stop()
+
+	clock.Advance(2*time.Minute + 30*time.Second)
+	cron.Stop()
+	clock.Advance(time.Hour)
+
+	expect := []string{"nested@00:00:15", "cron@00:01:00", "after@00:01:30", "cron@00:02:00"}
+	if !reflect.DeepEqual(fired, expect) {
+		t.Fatalf("expect %v, got %v", expect, fired)
+	}
+}
+
+func TestMockClock_LoopExited(t *testing.T) {
+	clock := NewMockClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	g := NewGoroutineMixInWithClock(clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		g.Run(ctx)
+		close(exited)
+	}()
+	cancel()
+	<-exited
+
+	g.AfterFunc(time.Second, func() {})
+	done := make(chan struct{})
+	go func() {
+		//模块协程已经退出，Advance不能等待回调执行
+		clock.Advance(time.Minute)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("advance blocked after module loop exited")
+	}
+}
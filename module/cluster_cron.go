@@ -0,0 +1,243 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"os"
+	"sync"
+	"time"
+)
+
+/**  集群中只有一个实例执行的定时任务
+  *  每次执行前以"任务名:计划执行时间"为key抢占租约，抢到的实例才执行
+  *  执行完毕后不释放租约，等待其过期，这样时钟有偏差的实例也不会重复执行
+**/
+
+// Lease 分布式租约，可以用redis(redisutil.Lease)、etcd等实现，测试时可以用MemoryLease
+type Lease interface {
+	// Acquire key不存在时占有租约，成功返回true
+	Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Renew 延长自己持有的租约，租约已经被别人占有时返回false
+	Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Release 释放自己持有的租约
+	Release(ctx context.Context, key, owner string) error
+}
+
+// CronRun 一次定时任务的执行结果
+type CronRun struct {
+	Name  string
+	Owner string
+	// At 计划执行的时间
+	At time.Time
+	// Executed 是否在本实例执行；没有抢到租约时为false
+	Executed bool
+	Start    time.Time
+	End      time.Time
+	// Err 抢占租约或者任务本身的错误
+	Err error
+}
+
+// ClusterCron 集群单例的定时任务，任务在所属模块的协程中执行
+type ClusterCron struct {
+	g     *GoroutineMixIn
+	lease Lease
+	owner string
+	ttl   time.Duration
+	onRun func(CronRun)
+
+	mu       sync.RWMutex
+	lastRuns map[string]CronRun
+}
+
+// NewClusterCron 创建集群定时任务，owner是当前实例的标识，为空时使用hostname-pid
+// ttl是租约的有效期，需要大于各实例之间的时钟偏差，<=0时默认1分钟
+func NewClusterCron(g *GoroutineMixIn, lease Lease, owner string, ttl time.Duration) *ClusterCron {
+	if owner == "" {
+		host, _ := os.Hostname()
+		owner = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	return &ClusterCron{
+		g:        g,
+		lease:    lease,
+		owner:    owner,
+		ttl:      ttl,
+		lastRuns: make(map[string]CronRun),
+	}
+}
+
+// OnRun 每次执行(包括没有抢到租约而跳过)之后的回调，在模块协程中执行
+func (cc *ClusterCron) OnRun(cb func(CronRun)) {
+	cc.onRun = cb
+}
+
+// LastRun 任务最近一次的执行结果，goroutine safe
+func (cc *ClusterCron) LastRun(name string) (CronRun, bool) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	r, ok := cc.lastRuns[name]
+	return r, ok
+}
+
+// CronFunc 按cron表达式执行cb，集群中每个时间点只有一个实例会执行，name需要在集群中唯一
+func (cc *ClusterCron) CronFunc(name string, cronExpr *CronExpr, cb func() error, jitter ...time.Duration) *Cron {
+	return cc.g.dispatcher.cronFunc(cronExpr, func(at time.Time) {
+		cc.fire(name, at, cb)
+	}, jitter...)
+}
+
+func (cc *ClusterCron) fire(name string, at time.Time, cb func() error) {
+	key := fmt.Sprintf("%s:%d", name, at.Unix())
+	run := CronRun{Name: name, Owner: cc.owner, At: at}
+	var ok bool
+	//抢占租约会有网络IO，不能在模块协程中执行
+	cc.g.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cc.ttl)
+		defer cancel()
+		ok, run.Err = cc.lease.Acquire(ctx, key, cc.owner, cc.ttl)
+	}, func() {
+		if ok {
+			cc.execute(key, &run, cb)
+		}
+		cc.record(run)
+	})
+}
+
+func (cc *ClusterCron) execute(key string, run *CronRun, cb func() error) {
+	stop := cc.keepAlive(key)
+	defer func() {
+		stop()
+		run.End = cc.g.Clock().Now()
+		if r := recover(); r != nil {
+			log.PanicStack(fmt.Sprintf("cluster cron %s panic", run.Name), r)
+			run.Err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	run.Executed = true
+	run.Start = cc.g.Clock().Now()
+	run.Err = cb()
+}
+
+// keepAlive 任务执行时间比较长时续约，防止租约过期之后其他实例重复执行，返回停止续约的函数
+// 续约的间隔使用模块的时钟，测试时可以用MockClock控制
+func (cc *ClusterCron) keepAlive(key string) (stop func()) {
+	interval := cc.ttl / 3
+	tick := make(chan struct{}, 1)
+	done := make(chan struct{})
+	var (
+		mu      sync.Mutex
+		timer   ClockTimer
+		stopped bool
+	)
+	schedule := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		timer = cc.g.Clock().AfterFunc(interval, func() {
+			select {
+			case tick <- struct{}{}:
+			default:
+			}
+		})
+	}
+	schedule()
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				ok, err := cc.lease.Renew(ctx, key, cc.owner, cc.ttl)
+				cancel()
+				if err != nil || !ok {
+					log.Warn("fail to renew lease %s, ok:%v, err:%v", key, ok, err)
+				}
+				schedule()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		mu.Lock()
+		stopped = true
+		timer.Stop()
+		mu.Unlock()
+	}
+}
+
+func (cc *ClusterCron) record(run CronRun) {
+	if run.Err != nil {
+		log.Error("cluster cron %s@%v failed: %v", run.Name, run.At, run.Err)
+	}
+	cc.mu.Lock()
+	cc.lastRuns[run.Name] = run
+	cc.mu.Unlock()
+	if cc.onRun != nil {
+		cc.onRun(run)
+	}
+}
+
+type memoryLeaseEntry struct {
+	owner  string
+	expire time.Time
+}
+
+// MemoryLease 进程内的租约实现，用于测试或者单机部署
+type MemoryLease struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[string]memoryLeaseEntry
+}
+
+func NewMemoryLease() *MemoryLease {
+	return NewMemoryLeaseWithClock(RealClock)
+}
+
+// NewMemoryLeaseWithClock 租约的过期时间使用指定的时钟计算
+func NewMemoryLeaseWithClock(clock Clock) *MemoryLease {
+	return &MemoryLease{clock: clock, entries: make(map[string]memoryLeaseEntry)}
+}
+
+func (l *MemoryLease) get(key string) (memoryLeaseEntry, bool) {
+	e, ok := l.entries[key]
+	if ok && !l.clock.Now().Before(e.expire) {
+		delete(l.entries, key)
+		return e, false
+	}
+	return e, ok
+}
+
+func (l *MemoryLease) Acquire(_ context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.get(key); ok {
+		return false, nil
+	}
+	l.entries[key] = memoryLeaseEntry{owner: owner, expire: l.clock.Now().Add(ttl)}
+	return true, nil
+}
+
+func (l *MemoryLease) Renew(_ context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.get(key); ok && e.owner != owner {
+		return false, nil
+	}
+	l.entries[key] = memoryLeaseEntry{owner: owner, expire: l.clock.Now().Add(ttl)}
+	return true, nil
+}
+
+func (l *MemoryLease) Release(_ context.Context, key, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.get(key); ok && e.owner == owner {
+		delete(l.entries, key)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClusterCron(t *testing.T) {
+	lease := NewMemoryLease()
+	expr, _ := NewCronExpr("* * * * * *")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var executed, skipped int32
+	for i := 0; i < 3; i++ {
+		g := NewGoroutineMixIn()
+		cc := NewClusterCron(g, lease, fmt.Sprintf("node%d", i), time.Minute)
+		cc.OnRun(func(run CronRun) {
+			if run.Executed {
+				atomic.AddInt32(&executed, 1)
+			} else {
+				atomic.AddInt32(&skipped, 1)
+			}
+		})
+		cc.CronFunc("report", expr, func() error { return nil })
+		go g.Run(ctx)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	//每个时间点3个节点中只有1个执行
+	e, s := atomic.LoadInt32(&executed), atomic.LoadInt32(&skipped)
+	if e == 0 || s != 2*e {
+		t.Fatalf("expect one node per firing, executed:%d, skipped:%d", e, s)
+	}
+}
+
+// countingLease 记录续约次数
+type countingLease struct {
+	*MemoryLease
+	renews int32
+}
+
+func (l *countingLease) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	atomic.AddInt32(&l.renews, 1)
+	return l.MemoryLease.Renew(ctx, key, owner, ttl)
+}
+
+func TestClusterCron_KeepAlive(t *testing.T) {
+	clock := NewMockClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	lease := &countingLease{MemoryLease: NewMemoryLeaseWithClock(clock)}
+	g := NewGoroutineMixInWithClock(clock)
+	cc := NewClusterCron(g, lease, "node", 30*time.Second)
+	runs := make(chan CronRun, 1)
+	cc.OnRun(func(run CronRun) { runs <- run })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+
+	expr, _ := NewCronExpr("@every 1m")
+	cc.CronFunc("long", expr, func() error {
+		//任务执行期间时钟前进，按ttl/3续约
+		for i := int32(1); i <= 2; i++ {
+			clock.Advance(10 * time.Second)
+			for atomic.LoadInt32(&lease.renews) < i {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		return nil
+	})
+	clock.Advance(time.Minute)
+	select {
+	case run := <-runs:
+		if !run.Executed || run.Err != nil {
+			t.Fatalf("unexpected run %+v", run)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("cron not executed")
+	}
+	//任务结束之后不再续约
+	if n := clock.Pending(); n != 1 {
+		t.Fatalf("expect only the next cron timer pending, got %d", n)
+	}
+}
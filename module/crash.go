@@ -0,0 +1,121 @@
+package module
+
+import (
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"runtime/debug"
+	"time"
+)
+
+/**  处理rpc请求和定时器回调时panic的隔离策略
+  *  默认记录日志之后继续处理下一个消息，模块可能处于不一致的状态，可以选择重启模块或者关闭整个服务
+**/
+
+// PanicPolicy 处理消息时panic的处理方式
+type PanicPolicy int
+
+const (
+	// PanicContinue 记录日志，继续处理下一个消息
+	PanicContinue PanicPolicy = iota
+	// PanicRestart 模块主循环以panic退出，由server重启模块(不受RestartPolicy.Mode限制，但MaxRestarts和Backoff仍然有效)
+	PanicRestart
+	// PanicExit 关闭整个服务
+	PanicExit
+)
+
+// TimerCallId 定时器回调panic时，CrashReport.Id的值
+const TimerCallId = "timer"
+
+// maxArgsSummary 崩溃报告中参数摘要的最大长度
+const maxArgsSummary = 256
+
+// CrashReport 模块崩溃报告
+// 作为error使用时，表示模块主循环因为PanicPolicy退出
+type CrashReport struct {
+	Module string
+	// Id 消息id，定时器为TimerCallId，模块主循环本身panic时为nil
+	Id any
+	// Args 参数的摘要，过长时会被截断
+	Args    string
+	Message string
+	Stack   string
+	// Policy 消息处理panic时模块的策略，模块主循环本身panic时由RestartPolicy决定是否重启
+	Policy PanicPolicy
+	Time   time.Time
+}
+
+func (r *CrashReport) Error() string {
+	return fmt.Sprintf("module %s panic in function id %v: %s", r.Module, r.Id, r.Message)
+}
+
+// PanicGuard 可选接口，server通过它设置模块的panic策略和崩溃报告的回调
+// GoroutineMixIn已经实现了这个接口
+type PanicGuard interface {
+	SetPanicPolicy(module string, policy PanicPolicy, hook func(CrashReport))
+}
+
+// PanicIsolated 可选接口，声明模块自己的panic策略
+// 没有实现时使用server中设置的默认策略
+type PanicIsolated interface {
+	PanicPolicy() PanicPolicy
+}
+
+type panicGuard struct {
+	module string
+	policy PanicPolicy
+	hook   func(CrashReport)
+}
+
+// SetPanicPolicy 设置处理rpc请求时panic的策略，hook可以为nil，需要在模块运行之前调用
+// 使用server时不需要手动调用，server会按照模块的PanicIsolated和OnModuleCrash设置
+func (s *RpcServer) SetPanicPolicy(module string, policy PanicPolicy, hook func(CrashReport)) {
+	s.guard = &panicGuard{module: module, policy: policy, hook: hook}
+}
+
+// SetPanicPolicy 同时设置rpc请求和定时器回调的panic策略
+func (s *GoroutineMixIn) SetPanicPolicy(module string, policy PanicPolicy, hook func(CrashReport)) {
+	s.RpcServer.SetPanicPolicy(module, policy, hook)
+	s.dispatcher.guard = s.RpcServer.guard
+}
+
+// recovered 在recover之后调用，记录日志并回调hook
+func (g *panicGuard) recovered(id any, args []any, r any) *CrashReport {
+	report := &CrashReport{
+		Id:      id,
+		Args:    SummarizeArgs(args),
+		Message: fmt.Sprint(r),
+		Stack:   string(debug.Stack()),
+		Time:    time.Now(),
+	}
+	if g != nil {
+		report.Module, report.Policy = g.module, g.policy
+	}
+	log.Error("module %s panic in function id %v(%s): %s-> %s", report.Module, id, report.Args, report.Message, report.Stack)
+	if g != nil && g.hook != nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.PanicStack("panic in module crash hook", r)
+				}
+			}()
+			g.hook(*report)
+		}()
+	}
+	return report
+}
+
+// escalate 按照策略，需要时让模块主循环panic退出
+func (r *CrashReport) escalate() {
+	if r.Policy != PanicContinue {
+		panic(r)
+	}
+}
+
+// SummarizeArgs 参数的摘要，用于日志和崩溃报告
+func SummarizeArgs(args []any) string {
+	s := fmt.Sprintf("%v", args)
+	if len(s) > maxArgsSummary {
+		s = s[:maxArgsSummary] + "..."
+	}
+	return s
+}
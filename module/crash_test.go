@@ -0,0 +1,72 @@
+package module
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanicPolicy_Continue(t *testing.T) {
+	g := NewGoroutineMixInWithClock(NewMockClock(time.Now()))
+	var reports []CrashReport
+	g.SetPanicPolicy("m", PanicContinue, func(r CrashReport) {
+		reports = append(reports, r)
+	})
+	g.Register("boom", func(args []any) {
+		panic("boom")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		g.Run(ctx)
+		close(done)
+	}()
+	if err := g.Call0("boom", 1, strings.Repeat("x", 512)); err == nil || err.Error() != "boom" {
+		t.Fatalf("expect panic as error, got %v", err)
+	}
+	//模块继续运行
+	g.AfterFunc(time.Second, func() { panic("timer") })
+	g.Clock().(*MockClock).Advance(time.Second)
+	if err := g.Call0("boom"); err == nil {
+		t.Fatal("expect error")
+	}
+	cancel()
+	<-done
+	if len(reports) != 3 {
+		t.Fatalf("expect 3 reports, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.Module != "m" || r.Id != "boom" || r.Message != "boom" || !strings.Contains(r.Stack, "TestPanicPolicy_Continue") {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if !strings.HasPrefix(r.Args, "[1 xxx") || len(r.Args) != maxArgsSummary+3 {
+		t.Fatalf("unexpected args summary %s", r.Args)
+	}
+	if reports[1].Id != TimerCallId || reports[1].Message != "timer" {
+		t.Fatalf("unexpected report %+v", reports[1])
+	}
+}
+
+func TestPanicPolicy_Restart(t *testing.T) {
+	g := NewGoroutineMixIn()
+	g.SetPanicPolicy("m", PanicRestart, nil)
+	g.Register("boom", func(args []any) {
+		panic("boom")
+	})
+	crashed := make(chan any, 1)
+	go func() {
+		defer func() {
+			crashed <- recover()
+		}()
+		g.Run(context.Background())
+	}()
+	//调用方仍然能收到错误
+	if err := g.Call0("boom"); err == nil {
+		t.Fatal("expect error")
+	}
+	r, ok := (<-crashed).(*CrashReport)
+	if !ok || r.Policy != PanicRestart || r.Id != "boom" {
+		t.Fatalf("unexpected crash %+v", r)
+	}
+}
@@ -4,20 +4,28 @@ package module
 import (
 	"fmt"
 	"math"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
 //CronExpr 类似linux cron的表达式
-//Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+//Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------- | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - ? L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-7 or SUN-SAT  | * / , - ? L #
+//
+// L: 在day of month中表示当月最后一天，LW表示当月最后一个工作日；在day of week中，5L表示当月最后一个周五
+// W: 15W表示离15号最近的工作日(不会跨月)
+// #: 5#3表示当月第3个周五
+//
+// 也支持以下描述符：@yearly(@annually), @monthly, @weekly, @daily(@midnight), @hourly, @every <duration>
+// 表达式前面可以加上CRON_TZ=<时区>指定时区，如 CRON_TZ=Asia/Shanghai 0 0 * * *，默认使用本地时区
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -25,11 +33,82 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	domStar     bool     // day of month是*或?
+	dowStar     bool     // day of week是*或?
+	domLast     bool     // L: 当月最后一天
+	domLastWday bool     // LW: 当月最后一个工作日
+	domWday     uint64   // nW: 离n号最近的工作日
+	dowNth      [7]uint8 // n#m: 当月第m个周n，按位记录m
+	dowLast     uint8    // nL: 当月最后一个周n，按位记录n
+
+	every time.Duration  // @every
+	loc   *time.Location // nil表示使用传入时间的时区
 }
 
+var (
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+	monthNames = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+	dowNames = map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}
+	cronNameRegex = regexp.MustCompile(`[A-Za-z]{3}`)
+)
+
 // NewCronExpr 类似cron的单机定时器，goroutine safe
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
-	fields := strings.Fields(expr)
+	return NewCronExprInLocation(expr, nil)
+}
+
+// NewCronExprInLocation 在指定的时区解析cron表达式，表达式中的CRON_TZ优先
+func NewCronExprInLocation(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			err = fmt.Errorf("invalid expr %v: missing fields after time zone", expr)
+			return
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: bad time zone %v: %v", expr, name, err)
+			return
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		var d time.Duration
+		d, err = time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || d < time.Second {
+			err = fmt.Errorf("invalid expr %v: @every needs a duration >= 1s", expr)
+			return
+		}
+		cronExpr = &CronExpr{every: d.Truncate(time.Second), loc: loc}
+		return
+	}
+	if strings.HasPrefix(spec, "@") {
+		d, ok := cronDescriptors[spec]
+		if !ok {
+			err = fmt.Errorf("invalid expr %v: unknown descriptor %v", expr, spec)
+			return
+		}
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
 	if len(fields) != 5 && len(fields) != 6 {
 		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
 		return
@@ -40,6 +119,7 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 	}
 
 	cronExpr = new(CronExpr)
+	cronExpr.loc = loc
 	// Seconds
 	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
 	if err != nil {
@@ -56,20 +136,27 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 		goto onError
 	}
 	// Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	err = cronExpr.parseDomField(fields[3])
 	if err != nil {
 		goto onError
 	}
 	// Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(replaceCronNames(fields[4], monthNames), 1, 12)
 	if err != nil {
 		goto onError
 	}
 	// Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	err = cronExpr.parseDowField(replaceCronNames(fields[5], dowNames))
 	if err != nil {
 		goto onError
 	}
+	// 兼容之前的写法，1-31和0-6也当成*
+	if !cronExpr.domLast && !cronExpr.domLastWday && cronExpr.domWday == 0 && cronExpr.dom == 0xfffffffe {
+		cronExpr.domStar = true
+	}
+	if cronExpr.dowLast == 0 && cronExpr.dowNth == [7]uint8{} && cronExpr.dow == 0x7f {
+		cronExpr.dowStar = true
+	}
 	return
 
 onError:
@@ -77,6 +164,99 @@ onError:
 	return
 }
 
+// replaceCronNames 把JAN/MON之类的名字替换成数字，不认识的保持原样
+func replaceCronNames(field string, names map[string]int) string {
+	return cronNameRegex.ReplaceAllStringFunc(field, func(s string) string {
+		if n, ok := names[strings.ToUpper(s)]; ok {
+			return strconv.Itoa(n)
+		}
+		return s
+	})
+}
+
+// splitSpecial 把逗号分隔的字段中带特殊字符的部分交给special处理，其他部分重新拼起来返回
+func splitSpecial(field string, special func(part string) (bool, error)) (string, error) {
+	var normal []string
+	for _, part := range strings.Split(field, ",") {
+		ok, err := special(part)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			normal = append(normal, part)
+		}
+	}
+	return strings.Join(normal, ","), nil
+}
+
+func (e *CronExpr) parseDomField(field string) error {
+	if field == "*" || field == "?" {
+		e.domStar = true
+		e.dom, _ = parseCronField("*", 1, 31)
+		return nil
+	}
+	normal, err := splitSpecial(strings.ToUpper(field), func(part string) (bool, error) {
+		switch {
+		case part == "L":
+			e.domLast = true
+		case part == "LW":
+			e.domLastWday = true
+		case strings.HasSuffix(part, "W"):
+			day, err := strconv.Atoi(strings.TrimSuffix(part, "W"))
+			if err != nil || day < 1 || day > 31 {
+				return false, fmt.Errorf("invalid weekday modifier: %v", part)
+			}
+			e.domWday |= 1 << uint(day)
+		default:
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil || normal == "" {
+		return err
+	}
+	e.dom, err = parseCronField(normal, 1, 31)
+	return err
+}
+
+func (e *CronExpr) parseDowField(field string) error {
+	if field == "*" || field == "?" {
+		e.dowStar = true
+		e.dow, _ = parseCronField("*", 0, 6)
+		return nil
+	}
+	normal, err := splitSpecial(strings.ToUpper(field), func(part string) (bool, error) {
+		switch {
+		case strings.Contains(part, "#"):
+			wdAndNth := strings.Split(part, "#")
+			wd, err1 := strconv.Atoi(wdAndNth[0])
+			nth, err2 := strconv.Atoi(wdAndNth[len(wdAndNth)-1])
+			if len(wdAndNth) != 2 || err1 != nil || err2 != nil || wd < 0 || wd > 7 || nth < 1 || nth > 5 {
+				return false, fmt.Errorf("invalid nth weekday: %v", part)
+			}
+			e.dowNth[wd%7] |= 1 << uint(nth)
+		case strings.HasSuffix(part, "L"):
+			wd, err := strconv.Atoi(strings.TrimSuffix(part, "L"))
+			if err != nil || wd < 0 || wd > 7 {
+				return false, fmt.Errorf("invalid last weekday: %v", part)
+			}
+			e.dowLast |= 1 << uint(wd%7)
+		default:
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil || normal == "" {
+		return err
+	}
+	e.dow, err = parseCronField(normal, 0, 7)
+	// 7也表示周日
+	if e.dow&(1<<7) != 0 {
+		e.dow = e.dow&^(1<<7) | 1
+	}
+	return err
+}
+
 // 1. *
 // 2. num
 // 3. num-num
@@ -172,23 +352,89 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 	return
 }
 
+func daysIn(year int, month time.Month, loc *time.Location) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+}
+
+// nearestWeekday 离day最近的工作日，不会跨月
+func nearestWeekday(year int, month time.Month, day int, loc *time.Location) int {
+	last := daysIn(year, month, loc)
+	if day > last {
+		return -1
+	}
+	switch time.Date(year, month, day, 0, 0, 0, 0, loc).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return 3
+		}
+		return day - 1
+	case time.Sunday:
+		if day == last {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
+
+func (e *CronExpr) matchDom(t time.Time) bool {
+	if 1<<uint(t.Day())&e.dom != 0 {
+		return true
+	}
+	last := daysIn(t.Year(), t.Month(), t.Location())
+	if e.domLast && t.Day() == last {
+		return true
+	}
+	if e.domLastWday && t.Day() == nearestWeekday(t.Year(), t.Month(), last, t.Location()) {
+		return true
+	}
+	for day := 1; e.domWday>>uint(day) != 0; day++ {
+		if e.domWday&(1<<uint(day)) != 0 && t.Day() == nearestWeekday(t.Year(), t.Month(), day, t.Location()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	wd := t.Weekday()
+	if 1<<uint(wd)&e.dow != 0 {
+		return true
+	}
+	if e.dowNth[wd]&(1<<uint((t.Day()-1)/7+1)) != 0 {
+		return true
+	}
+	return e.dowLast&(1<<uint(wd)) != 0 && t.Day()+7 > daysIn(t.Year(), t.Month(), t.Location())
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
 	// day-of-month blank
-	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+	if e.domStar {
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
-	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+	if e.dowStar {
+		return e.matchDom(t)
 	}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+	return e.matchDow(t) || e.matchDom(t)
 }
 
 // Next 返回下一个执行时间，goroutine safe
 func (e *CronExpr) Next(t time.Time) time.Time {
+	if e.every > 0 {
+		return t.Truncate(time.Second).Add(e.every)
+	}
+	if e.loc != nil && e.loc != t.Location() {
+		origin := t.Location()
+		return e.in(t.In(e.loc)).In(origin)
+	}
+	return e.in(t)
+}
+
+// in 在t所在的时区计算下一个执行时间
+func (e *CronExpr) in(t time.Time) time.Time {
 	// the upcoming second
 	t = t.Truncate(time.Second).Add(time.Second)
 
@@ -231,7 +477,8 @@ retry:
 	for 1<<uint(t.Hour())&e.hour == 0 {
 		if !initFlag {
 			initFlag = true
-			t = t.Truncate(time.Hour)
+			// 不能用Truncate，非整点时区会出错
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
 		}
 
 		t = t.Add(time.Hour)
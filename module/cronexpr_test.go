@@ -0,0 +1,87 @@
+package module
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCronExpr_Next(t *testing.T) {
+	local := time.UTC
+	from := time.Date(2023, 3, 10, 12, 30, 0, 0, local) // 周五
+	cases := []struct {
+		expr   string
+		expect time.Time
+	}{
+		{"0 0 * * *", time.Date(2023, 3, 11, 0, 0, 0, 0, local)},
+		{"@hourly", time.Date(2023, 3, 10, 13, 0, 0, 0, local)},
+		{"@daily", time.Date(2023, 3, 11, 0, 0, 0, 0, local)},
+		{"@monthly", time.Date(2023, 4, 1, 0, 0, 0, 0, local)},
+		{"@every 90s", time.Date(2023, 3, 10, 12, 31, 30, 0, local)},
+		{"0 0 1 JAN-MAR *", time.Date(2024, 1, 1, 0, 0, 0, 0, local)},
+		{"0 9 * * MON-FRI", time.Date(2023, 3, 13, 9, 0, 0, 0, local)},
+		{"0 0 * * 7", time.Date(2023, 3, 12, 0, 0, 0, 0, local)},
+		{"0 0 L * ?", time.Date(2023, 3, 31, 0, 0, 0, 0, local)},
+		{"0 0 LW 4 ?", time.Date(2023, 4, 28, 0, 0, 0, 0, local)},
+		{"0 0 1W 4 ?", time.Date(2023, 4, 3, 0, 0, 0, 0, local)},   // 4月1日是周六
+		{"0 0 30W 4 ?", time.Date(2023, 4, 28, 0, 0, 0, 0, local)}, // 4月30日是周日
+		{"0 0 ? * 5#3", time.Date(2023, 3, 17, 0, 0, 0, 0, local)},
+		{"0 0 ? * FRI#2", time.Date(2023, 4, 14, 0, 0, 0, 0, local)},
+		{"0 0 ? * 1L", time.Date(2023, 3, 27, 0, 0, 0, 0, local)},
+		{"CRON_TZ=Asia/Shanghai 0 0 * * *", time.Date(2023, 3, 10, 16, 0, 0, 0, local)},
+		{"CRON_TZ=Asia/Kolkata 0 * * * *", time.Date(2023, 3, 10, 12, 30, 0, 0, local).Add(time.Hour)},
+	}
+	for _, c := range cases {
+		e, err := NewCronExpr(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if next := e.Next(from); !next.Equal(c.expect) {
+			t.Errorf("%s: expect %v, got %v", c.expr, c.expect, next)
+		}
+	}
+}
+
+func TestCronExpr_Invalid(t *testing.T) {
+	for _, expr := range []string{"@every 1ms", "@never", "CRON_TZ=Mars/Base 0 * * * *", "0 0 32W * ?", "0 0 ? * 5#6", "0 0 * FOO *"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("%s: expect error", expr)
+		}
+	}
+}
+
+func TestCronFunc_Jitter(t *testing.T) {
+	dp := NewDispatcher()
+	for i := 0; i < 1000; i++ {
+		if d := dp.jitter(10 * time.Millisecond); d < 0 || d >= 10*time.Millisecond {
+			t.Fatalf("jitter %v out of range", d)
+		}
+	}
+	if d := dp.jitter(0); d != 0 {
+		t.Fatalf("expect no jitter, got %v", d)
+	}
+
+	clock := NewMockClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	g := NewGoroutineMixInWithClock(clock)
+	g.dispatcher.randInt63n = func(n int64) int64 {
+		if n != int64(10*time.Second) {
+			t.Errorf("unexpected jitter range %v", time.Duration(n))
+		}
+		return n / 2
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+
+	var fired []string
+	expr, _ := NewCronExpr("@every 1m")
+	cron := g.CronFunc(expr, func() {
+		fired = append(fired, g.Clock().Now().Format("15:04:05"))
+	}, 10*time.Second)
+	defer cron.Stop()
+	clock.Advance(90 * time.Second)
+	if expect := []string{"00:01:05"}; !reflect.DeepEqual(fired, expect) {
+		t.Fatalf("expect %v, got %v", expect, fired)
+	}
+}
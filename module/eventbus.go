@@ -0,0 +1,392 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**  进程内的事件总线，模块订阅主题，事件在订阅者自己的协程中处理
+  *  主题用.分隔，订阅时*匹配一段，#匹配零段或多段，比如user.*.login、order.#
+  *  每个订阅有独立的有界队列，队列满时按OverflowPolicy处理
+**/
+
+// OverflowPolicy 订阅队列满时的处理方式
+type OverflowPolicy int
+
+const (
+	// DropNewest 丢弃新的事件
+	DropNewest OverflowPolicy = iota
+	// DropOldest 丢弃队列中最早的事件
+	DropOldest
+	// Block 阻塞发布者直到队列有空位，不要在订阅者自己的协程中发布，否则会死锁
+	Block
+)
+
+const defaultEventQueueSize = 1024
+
+// Event 总线上的事件
+type Event struct {
+	Topic   string
+	Payload any
+	Time    time.Time
+}
+
+// EventStats 订阅的投递统计
+type EventStats struct {
+	Pattern string
+	// Queued 当前在队列中等待处理的事件数
+	Queued int
+	// Enqueued 进入队列的事件总数
+	Enqueued int64
+	// Delivered 已经处理的事件总数
+	Delivered int64
+	// Dropped 因为队列满、订阅者关闭等原因丢弃的事件总数
+	Dropped int64
+}
+
+type subscribeConfig struct {
+	queueSize int
+	overflow  OverflowPolicy
+}
+
+type SubscribeOption func(*subscribeConfig)
+
+// QueueSize 订阅队列的长度，默认1024
+func QueueSize(n int) SubscribeOption {
+	return func(c *subscribeConfig) {
+		c.queueSize = n
+	}
+}
+
+// Overflow 队列满时的处理方式，默认DropNewest
+func Overflow(p OverflowPolicy) SubscribeOption {
+	return func(c *subscribeConfig) {
+		c.overflow = p
+	}
+}
+
+// EventBus 事件总线，goroutine safe
+type EventBus struct {
+	//atomic操作的字段放在最前面，保证32位系统上的对齐
+	published int64
+	unmatched int64
+
+	mu   sync.RWMutex
+	seq  uint64
+	subs map[uint64]*Subscription
+}
+
+var defaultEventBus = NewEventBus()
+
+// DefaultEventBus 进程内默认的事件总线
+func DefaultEventBus() *EventBus {
+	return defaultEventBus
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[uint64]*Subscription)}
+}
+
+// Subscription 一个订阅，事件在server所在的协程中处理
+type Subscription struct {
+	enqueued  int64
+	delivered int64
+	dropped   int64
+
+	bus     *EventBus
+	id      uint64
+	pattern []string
+	raw     string
+	server  *RpcServer
+	accept  func(any) bool
+	handler func(Event)
+	cfg     subscribeConfig
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queue     []Event
+	scheduled bool //是否已经有投递任务在server的队列中
+	closed    bool
+	unhook    func() //取消server关闭时的回调
+}
+
+// Topic 带类型的主题，发布和订阅时检查payload的类型
+type Topic[T any] struct {
+	name string
+}
+
+func NewTopic[T any](name string) Topic[T] {
+	if strings.ContainsAny(name, "*#") {
+		panic(fmt.Sprintf("topic %s: wildcard not allowed", name))
+	}
+	return Topic[T]{name: name}
+}
+
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// Publish 发布事件，返回投递到的订阅数
+func (t Topic[T]) Publish(bus *EventBus, payload T) int {
+	return bus.Publish(t.name, payload)
+}
+
+// Subscribe 订阅主题，handler在server所在的协程中执行
+func (t Topic[T]) Subscribe(bus *EventBus, server *RpcServer, handler func(payload T), opts ...SubscribeOption) *Subscription {
+	return SubscribePattern(bus, server, t.name, func(_ string, payload T) {
+		handler(payload)
+	}, opts...)
+}
+
+// SubscribePattern 按通配符订阅，只接收payload类型是T的事件
+func SubscribePattern[T any](bus *EventBus, server *RpcServer, pattern string, handler func(topic string, payload T), opts ...SubscribeOption) *Subscription {
+	return bus.subscribe(server, pattern, func(payload any) bool {
+		_, ok := payload.(T)
+		return ok
+	}, func(e Event) {
+		handler(e.Topic, e.Payload.(T))
+	}, opts)
+}
+
+func (bus *EventBus) subscribe(server *RpcServer, pattern string, accept func(any) bool, handler func(Event), opts []SubscribeOption) *Subscription {
+	if server == nil {
+		panic("subscribe with nil rpc server")
+	}
+	cfg := subscribeConfig{queueSize: defaultEventQueueSize, overflow: DropNewest}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.queueSize <= 0 {
+		cfg.queueSize = defaultEventQueueSize
+	}
+	sub := &Subscription{
+		bus:     bus,
+		pattern: splitTopic(pattern),
+		raw:     pattern,
+		server:  server,
+		accept:  accept,
+		handler: handler,
+		cfg:     cfg,
+	}
+	sub.cond = sync.NewCond(&sub.mu)
+	bus.mu.Lock()
+	bus.seq++
+	sub.id = bus.seq
+	bus.subs[sub.id] = sub
+	bus.mu.Unlock()
+	//server停止接收请求或者关闭之后，投递任务不会再执行，订阅也随之关闭
+	unhook := server.addCloseHook(sub.Close)
+	sub.mu.Lock()
+	if sub.closed {
+		sub.mu.Unlock()
+		unhook()
+	} else {
+		sub.unhook = unhook
+		sub.mu.Unlock()
+	}
+	return sub
+}
+
+// Publish 发布事件，返回投递到的订阅数(包括因为队列满被丢弃的)
+// 订阅按订阅的先后顺序投递
+func (bus *EventBus) Publish(topic string, payload any) int {
+	atomic.AddInt64(&bus.published, 1)
+	e := Event{Topic: topic, Payload: payload, Time: time.Now()}
+	segments := splitTopic(topic)
+	bus.mu.RLock()
+	matched := make([]*Subscription, 0, len(bus.subs))
+	for _, sub := range bus.subs {
+		if matchTopic(sub.pattern, segments) && sub.accept(payload) {
+			matched = append(matched, sub)
+		}
+	}
+	bus.mu.RUnlock()
+	if len(matched) == 0 {
+		atomic.AddInt64(&bus.unmatched, 1)
+		return 0
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].id < matched[j].id
+	})
+	for _, sub := range matched {
+		sub.push(e)
+	}
+	return len(matched)
+}
+
+// Stats 所有订阅的投递统计
+func (bus *EventBus) Stats() []EventStats {
+	bus.mu.RLock()
+	subs := make([]*Subscription, 0, len(bus.subs))
+	for _, sub := range bus.subs {
+		subs = append(subs, sub)
+	}
+	bus.mu.RUnlock()
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].id < subs[j].id
+	})
+	resp := make([]EventStats, 0, len(subs))
+	for _, sub := range subs {
+		resp = append(resp, sub.Stats())
+	}
+	return resp
+}
+
+// Published 发布的事件总数，Unmatched 没有任何订阅者的事件数
+func (bus *EventBus) Published() (published, unmatched int64) {
+	return atomic.LoadInt64(&bus.published), atomic.LoadInt64(&bus.unmatched)
+}
+
+func splitTopic(topic string) []string {
+	return strings.Split(topic, ".")
+}
+
+// matchTopic *匹配一段，#匹配零段或多段
+func matchTopic(pattern, topic []string) bool {
+	if len(pattern) == 0 {
+		return len(topic) == 0
+	}
+	if pattern[0] == "#" {
+		for i := 0; i <= len(topic); i++ {
+			if matchTopic(pattern[1:], topic[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(topic) == 0 {
+		return false
+	}
+	if pattern[0] != "*" && pattern[0] != topic[0] {
+		return false
+	}
+	return matchTopic(pattern[1:], topic[1:])
+}
+
+func (sub *Subscription) push(e Event) {
+	sub.mu.Lock()
+	for !sub.closed && len(sub.queue) >= sub.cfg.queueSize {
+		switch sub.cfg.overflow {
+		case DropNewest:
+			sub.mu.Unlock()
+			atomic.AddInt64(&sub.dropped, 1)
+			return
+		case DropOldest:
+			sub.queue = sub.queue[1:]
+			atomic.AddInt64(&sub.dropped, 1)
+		case Block:
+			sub.cond.Wait()
+		}
+	}
+	if sub.closed {
+		sub.mu.Unlock()
+		atomic.AddInt64(&sub.dropped, 1)
+		return
+	}
+	sub.queue = append(sub.queue, e)
+	atomic.AddInt64(&sub.enqueued, 1)
+	if sub.scheduled {
+		sub.mu.Unlock()
+		return
+	}
+	sub.scheduled = true
+	sub.mu.Unlock()
+	sub.schedule()
+}
+
+// schedule 在订阅者的协程中投递一个事件，每次只处理一个，避免长时间占用模块协程
+func (sub *Subscription) schedule() {
+	if sub.server.Draining() {
+		sub.Close()
+		return
+	}
+	defer func() {
+		//server已经关闭
+		if r := recover(); r != nil {
+			sub.unschedule()
+			sub.Close()
+		}
+	}()
+	if err := sub.server.enqueue(context.Background(), &callInfo{id: sub.raw, f: sub.deliver, unlimited: true}); err != nil {
+		log.Warn("subscription %s: %v", sub.raw, err)
+		sub.unschedule()
+	}
+}
+
+// unschedule 投递任务没有进入server的队列，之后的事件需要重新投递
+func (sub *Subscription) unschedule() {
+	sub.mu.Lock()
+	sub.scheduled = false
+	sub.mu.Unlock()
+}
+
+func (sub *Subscription) deliver(_ []any) {
+	sub.mu.Lock()
+	if len(sub.queue) == 0 {
+		sub.scheduled = false
+		sub.mu.Unlock()
+		return
+	}
+	e := sub.queue[0]
+	sub.queue = sub.queue[1:]
+	sub.cond.Signal()
+	sub.mu.Unlock()
+	//handler panic时也要继续投递后面的事件
+	defer sub.next()
+	atomic.AddInt64(&sub.delivered, 1)
+	sub.handler(e)
+}
+
+func (sub *Subscription) next() {
+	sub.mu.Lock()
+	if len(sub.queue) == 0 || sub.closed {
+		sub.scheduled = false
+		sub.mu.Unlock()
+		return
+	}
+	sub.mu.Unlock()
+	sub.schedule()
+}
+
+// Close 取消订阅，队列中还没有处理的事件会被丢弃，goroutine safe
+func (sub *Subscription) Close() {
+	sub.bus.mu.Lock()
+	delete(sub.bus.subs, sub.id)
+	sub.bus.mu.Unlock()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	if n := len(sub.queue); n > 0 {
+		log.Warn("subscription %s closed, drop %d events", sub.raw, n)
+		atomic.AddInt64(&sub.dropped, int64(n))
+	}
+	sub.queue = nil
+	sub.cond.Broadcast()
+	if sub.unhook != nil {
+		sub.unhook()
+		sub.unhook = nil
+	}
+}
+
+// Stats 订阅的投递统计，goroutine safe
+func (sub *Subscription) Stats() EventStats {
+	sub.mu.Lock()
+	queued := len(sub.queue)
+	sub.mu.Unlock()
+	return EventStats{
+		Pattern:   sub.raw,
+		Queued:    queued,
+		Enqueued:  atomic.LoadInt64(&sub.enqueued),
+		Delivered: atomic.LoadInt64(&sub.delivered),
+		Dropped:   atomic.LoadInt64(&sub.dropped),
+	}
+}
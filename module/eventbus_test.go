@@ -0,0 +1,155 @@
+package module
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type loginEvent struct {
+	user string
+}
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"user.login", "user.login", true},
+		{"user.*", "user.login", true},
+		{"user.*", "user.login.failed", false},
+		{"user.#", "user", true},
+		{"user.#", "user.login.failed", true},
+		{"#.failed", "user.login.failed", true},
+		{"*.login", "order.logout", false},
+	}
+	for _, c := range cases {
+		if got := matchTopic(splitTopic(c.pattern), splitTopic(c.topic)); got != c.match {
+			t.Errorf("%s ~ %s: expect %v, got %v", c.pattern, c.topic, c.match, got)
+		}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	g := NewGoroutineMixIn()
+	login := NewTopic[*loginEvent]("user.login")
+	var users, topics []string
+	login.Subscribe(bus, g.RpcServer, func(e *loginEvent) {
+		users = append(users, e.user)
+	})
+	SubscribePattern(bus, g.RpcServer, "user.#", func(topic string, e *loginEvent) {
+		topics = append(topics, topic)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+
+	if n := login.Publish(bus, &loginEvent{user: "a"}); n != 2 {
+		t.Fatalf("expect 2 subscribers, got %d", n)
+	}
+	//类型不匹配的事件不会投递
+	if n := bus.Publish("user.login", "b"); n != 0 {
+		t.Fatalf("expect 0 subscribers, got %d", n)
+	}
+	bus.Publish("user.logout", &loginEvent{user: "c"})
+	//同步调用返回时，之前投递的事件都已经处理完毕
+	g.Register("sync", func([]any) {})
+	_ = g.Call0("sync")
+	_ = g.Call0("sync")
+	if len(users) != 1 || users[0] != "a" {
+		t.Fatalf("unexpected users %v", users)
+	}
+	if len(topics) != 2 || topics[1] != "user.logout" {
+		t.Fatalf("unexpected topics %v", topics)
+	}
+	if published, unmatched := bus.Published(); published != 3 || unmatched != 1 {
+		t.Fatalf("unexpected published %d, unmatched %d", published, unmatched)
+	}
+}
+
+func TestEventBus_Overflow(t *testing.T) {
+	bus := NewEventBus()
+	//模块协程不运行，事件会堆积在队列中
+	g := NewGoroutineMixIn()
+	topic := NewTopic[int]("n")
+	var newest, oldest []int
+	s1 := topic.Subscribe(bus, g.RpcServer, func(n int) { newest = append(newest, n) }, QueueSize(2))
+	s2 := topic.Subscribe(bus, g.RpcServer, func(n int) { oldest = append(oldest, n) }, QueueSize(2), Overflow(DropOldest))
+	blockTopic := NewTopic[int]("b")
+	s3 := blockTopic.Subscribe(bus, g.RpcServer, func(n int) {}, QueueSize(1), Overflow(Block))
+	for i := 1; i <= 3; i++ {
+		topic.Publish(bus, i)
+	}
+	blockTopic.Publish(bus, 1)
+	published := make(chan struct{})
+	go func() {
+		blockTopic.Publish(bus, 2)
+		blockTopic.Publish(bus, 3)
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("publish should block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+	<-published
+	g.Register("sync", func([]any) {})
+	for i := 0; i < 3; i++ {
+		_ = g.Call0("sync")
+	}
+	if len(newest) != 2 || newest[1] != 2 {
+		t.Fatalf("drop newest: unexpected %v", newest)
+	}
+	if len(oldest) != 2 || oldest[0] != 2 || oldest[1] != 3 {
+		t.Fatalf("drop oldest: unexpected %v", oldest)
+	}
+	if st := s1.Stats(); st.Dropped != 1 || st.Delivered != 2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if st := s3.Stats(); st.Dropped != 0 || st.Delivered != 3 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	s2.Close()
+	if n := topic.Publish(bus, 4); n != 1 {
+		t.Fatalf("expect 1 subscriber after close, got %d", n)
+	}
+}
+
+func TestEventBus_ServerClosed(t *testing.T) {
+	bus := NewEventBus()
+	topic := NewTopic[int]("n")
+	//投递任务在server的队列中时server关闭，订阅随之关闭，阻塞的发布者也会返回
+	s := NewRpcServer()
+	sub := topic.Subscribe(bus, s, func(int) {}, QueueSize(1), Overflow(Block))
+	topic.Publish(bus, 1)
+	published := make(chan struct{})
+	go func() {
+		topic.Publish(bus, 2)
+		close(published)
+	}()
+	s.Close()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publisher should return after server closed")
+	}
+	if n := topic.Publish(bus, 3); n != 0 || len(bus.Stats()) != 0 {
+		t.Fatalf("subscription should be removed, got %d", n)
+	}
+	if st := sub.Stats(); st.Delivered != 0 || st.Queued != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	//Drain之后同样关闭，已经Drain的server订阅之后立即关闭
+	g := NewGoroutineMixIn()
+	topic.Subscribe(bus, g.RpcServer, func(int) {})
+	g.Drain()
+	topic.Subscribe(bus, g.RpcServer, func(int) {})
+	if n := topic.Publish(bus, 4); n != 0 {
+		t.Fatalf("expect no subscriber after drain, got %d", n)
+	}
+}
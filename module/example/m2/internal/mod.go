@@ -37,6 +37,11 @@ func (m *mod) Tags() []string {
 	return nil
 }
 
+// DependsOn http服务会调用模块1，所以模块1需要先启动
+func (m *mod) DependsOn() []string {
+	return []string{g.Mod1}
+}
+
 func (m *mod) OnDestroy() {
 }
 
@@ -0,0 +1,275 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"github.com/YiuTerran/go-common/base/structs/fsm"
+	"time"
+)
+
+/**  挂在模块上的状态机，每个实体(设备、会话等)一个实例，状态转换都在模块协程中执行
+  *  状态可以设置进入/离开的回调和超时，超时通过模块的定时器触发
+  *  每个实例保留最近的状态转换记录，方便排查问题
+**/
+
+const defaultFSMHistorySize = 16
+
+// ErrFSMInstanceNotFound 实体没有对应的状态机实例
+var ErrFSMInstanceNotFound = errors.New("fsm instance not found")
+
+// FSMTransition 接收到输入之后转换到的状态
+type FSMTransition struct {
+	State int
+	// Action 在离开旧状态之后、进入新状态之前执行，可以为nil
+	// 返回fsm.NoInput以外的值时，进入新状态之后继续用这个输入驱动状态机
+	Action func(inst *FSMInstance, arg any) fsm.Input
+}
+
+// FSMState 状态的定义
+type FSMState struct {
+	Index int
+	Name  string
+	// Transitions 输入 -> 转换，没有定义的输入会返回fsm.InvalidInputError
+	Transitions map[fsm.Input]FSMTransition
+	OnEnter     func(inst *FSMInstance)
+	OnExit      func(inst *FSMInstance)
+	// Timeout 进入状态之后超过这个时间还没有离开，就输入TimeoutInput，0表示不超时
+	Timeout      time.Duration
+	TimeoutInput fsm.Input
+	// Final 终止状态，进入之后(执行完OnEnter)实例会被删除
+	Final bool
+}
+
+// FSMRecord 一次状态转换的记录
+type FSMRecord struct {
+	From  int
+	To    int
+	Input fsm.Input
+	Time  time.Time
+}
+
+// FSMInstance 一个实体的状态机实例，只能在模块协程中访问
+type FSMInstance struct {
+	Key any
+	// Data 业务数据
+	Data any
+
+	actor     *FSMActor
+	current   int
+	enteredAt time.Time
+	timer     *Timer
+	history   []FSMRecord
+	removed   bool
+}
+
+// fsmInputId 状态机输入在RpcServer中注册的id
+type fsmInputId struct {
+	name string
+}
+
+// FSMActor 状态机的定义和所有实例
+type FSMActor struct {
+	name        string
+	s           *GoroutineMixIn
+	states      map[int]*FSMState
+	initial     int
+	instances   map[any]*FSMInstance
+	historySize int
+}
+
+// NewFSMActor 定义状态机，第一个状态是初始状态，需要在模块运行之前调用
+// name在同一个模块内不能重复，会用来注册rpc
+func NewFSMActor(s *GoroutineMixIn, name string, states ...FSMState) (*FSMActor, error) {
+	if len(states) == 0 {
+		return nil, fmt.Errorf("fsm %s: no state defined", name)
+	}
+	a := &FSMActor{
+		name:        name,
+		s:           s,
+		states:      make(map[int]*FSMState, len(states)),
+		initial:     states[0].Index,
+		instances:   make(map[any]*FSMInstance),
+		historySize: defaultFSMHistorySize,
+	}
+	for i := range states {
+		st := states[i]
+		if _, ok := a.states[st.Index]; ok {
+			return nil, fsm.ClashingStateError(st.Index)
+		}
+		a.states[st.Index] = &st
+	}
+	for _, st := range a.states {
+		for _, tr := range st.Transitions {
+			if _, ok := a.states[tr.State]; !ok {
+				return nil, fsm.ImpossibleStateError(tr.State)
+			}
+		}
+	}
+	s.Register(fsmInputId{name: name}, func(args []any) {
+		if err := a.Spin(args[0], args[1].(fsm.Input), args[2]); err != nil {
+			log.Warn("fsm %s, key %v: %v", a.name, args[0], err)
+		}
+	})
+	return a, nil
+}
+
+// SetHistorySize 每个实例保留的状态转换记录数，默认16，<=0表示不记录
+func (a *FSMActor) SetHistorySize(n int) {
+	a.historySize = n
+}
+
+// Spawn 创建实例并进入初始状态，已经存在时返回旧的实例，只能在模块协程中调用
+func (a *FSMActor) Spawn(key any, data any) *FSMInstance {
+	if inst, ok := a.instances[key]; ok {
+		return inst
+	}
+	inst := &FSMInstance{Key: key, Data: data, actor: a, current: a.initial}
+	a.instances[key] = inst
+	a.enter(inst, a.states[a.initial])
+	return inst
+}
+
+// Get 获取实例，不存在时返回nil，只能在模块协程中调用
+func (a *FSMActor) Get(key any) *FSMInstance {
+	return a.instances[key]
+}
+
+// Len 实例的数量，只能在模块协程中调用
+func (a *FSMActor) Len() int {
+	return len(a.instances)
+}
+
+// Remove 删除实例，不会执行OnExit，只能在模块协程中调用
+func (a *FSMActor) Remove(key any) {
+	if inst, ok := a.instances[key]; ok {
+		a.remove(inst)
+	}
+}
+
+// Fire 在模块协程中用input驱动key对应的实例，goroutine safe
+// 出错时只记录日志，需要知道结果时在模块协程中调用Spin
+func (a *FSMActor) Fire(key any, input fsm.Input, arg any) {
+	a.s.RpcServer.Go(fsmInputId{name: a.name}, key, input, arg)
+}
+
+// Spin 用input驱动key对应的实例，Action返回新的输入时会继续驱动，只能在模块协程中调用
+func (a *FSMActor) Spin(key any, input fsm.Input, arg any) error {
+	inst, ok := a.instances[key]
+	if !ok {
+		return ErrFSMInstanceNotFound
+	}
+	for in := input; in != fsm.NoInput && !inst.removed; {
+		from := a.states[inst.current]
+		tr, ok := from.Transitions[in]
+		if !ok {
+			return fsm.InvalidInputError{StateIndex: inst.current, Input: in}
+		}
+		a.exit(inst, from)
+		next := fsm.NoInput
+		if tr.Action != nil {
+			next = tr.Action(inst, arg)
+		}
+		inst.record(FSMRecord{From: from.Index, To: tr.State, Input: in, Time: a.s.Clock().Now()})
+		inst.current = tr.State
+		a.enter(inst, a.states[tr.State])
+		in = next
+	}
+	return nil
+}
+
+func (a *FSMActor) enter(inst *FSMInstance, st *FSMState) {
+	inst.enteredAt = a.s.Clock().Now()
+	if st.OnEnter != nil {
+		st.OnEnter(inst)
+	}
+	if inst.removed || inst.current != st.Index {
+		return
+	}
+	if st.Final {
+		a.remove(inst)
+		return
+	}
+	if st.Timeout > 0 {
+		input := st.TimeoutInput
+		inst.timer = a.s.AfterFunc(st.Timeout, func() {
+			inst.timer = nil
+			if err := a.Spin(inst.Key, input, nil); err != nil {
+				log.Warn("fsm %s, key %v: timeout in state %s: %v", a.name, inst.Key, st.Name, err)
+			}
+		})
+	}
+}
+
+func (a *FSMActor) exit(inst *FSMInstance, st *FSMState) {
+	inst.stopTimer()
+	if st.OnExit != nil {
+		st.OnExit(inst)
+	}
+}
+
+func (a *FSMActor) remove(inst *FSMInstance) {
+	inst.stopTimer()
+	inst.removed = true
+	if a.instances[inst.Key] == inst {
+		delete(a.instances, inst.Key)
+	}
+}
+
+// State 当前状态
+func (inst *FSMInstance) State() int {
+	return inst.current
+}
+
+// StateName 当前状态的名称
+func (inst *FSMInstance) StateName() string {
+	return inst.actor.stateName(inst.current)
+}
+
+// EnteredAt 进入当前状态的时间
+func (inst *FSMInstance) EnteredAt() time.Time {
+	return inst.enteredAt
+}
+
+// History 最近的状态转换记录，从旧到新
+func (inst *FSMInstance) History() []FSMRecord {
+	resp := make([]FSMRecord, len(inst.history))
+	copy(resp, inst.history)
+	return resp
+}
+
+// HistoryString 可读的状态转换记录，用于日志
+func (inst *FSMInstance) HistoryString() string {
+	var b []byte
+	for _, r := range inst.history {
+		b = append(b, fmt.Sprintf("%s %s -(%d)-> %s\n", r.Time.Format(time.RFC3339Nano),
+			inst.actor.stateName(r.From), r.Input, inst.actor.stateName(r.To))...)
+	}
+	return string(b)
+}
+
+func (a *FSMActor) stateName(index int) string {
+	if st, ok := a.states[index]; ok && st.Name != "" {
+		return st.Name
+	}
+	return fmt.Sprint(index)
+}
+
+func (inst *FSMInstance) record(r FSMRecord) {
+	n := inst.actor.historySize
+	if n <= 0 {
+		return
+	}
+	if len(inst.history) >= n {
+		copy(inst.history, inst.history[len(inst.history)-n+1:])
+		inst.history = inst.history[:n-1]
+	}
+	inst.history = append(inst.history, r)
+}
+
+func (inst *FSMInstance) stopTimer() {
+	if inst.timer != nil {
+		inst.timer.Stop()
+		inst.timer = nil
+	}
+}
@@ -0,0 +1,147 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"github.com/YiuTerran/go-common/base/structs/fsm"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	sessIdle = iota
+	sessRinging
+	sessTalking
+	sessClosed
+)
+
+const (
+	inInvite fsm.Input = iota
+	inAnswer
+	inBye
+	inTimeout
+)
+
+func newSessionActor(t *testing.T, g *GoroutineMixIn, events *[]string) *FSMActor {
+	hook := func(ev string) func(*FSMInstance) {
+		return func(inst *FSMInstance) {
+			*events = append(*events, ev)
+		}
+	}
+	a, err := NewFSMActor(g, "session",
+		FSMState{Index: sessIdle, Name: "idle", Transitions: map[fsm.Input]FSMTransition{
+			inInvite: {State: sessRinging},
+		}},
+		FSMState{Index: sessRinging, Name: "ringing", OnEnter: hook("enter ringing"), OnExit: hook("exit ringing"),
+			Timeout: time.Minute, TimeoutInput: inTimeout,
+			Transitions: map[fsm.Input]FSMTransition{
+				inAnswer: {State: sessTalking, Action: func(inst *FSMInstance, arg any) fsm.Input {
+					inst.Data = arg
+					return fsm.NoInput
+				}},
+				inTimeout: {State: sessClosed},
+			}},
+		FSMState{Index: sessTalking, Name: "talking", Transitions: map[fsm.Input]FSMTransition{
+			inBye: {State: sessClosed},
+		}},
+		FSMState{Index: sessClosed, Name: "closed", Final: true, OnEnter: hook("enter closed")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestFSMActor_Spin(t *testing.T) {
+	g := NewGoroutineMixInWithClock(NewMockClock(time.Now()))
+	var events []string
+	a := newSessionActor(t, g, &events)
+	inst := a.Spawn("s1", nil)
+	if err := a.Spin("s1", inBye, nil); !errors.As(err, &fsm.InvalidInputError{}) {
+		t.Fatalf("expect invalid input, got %v", err)
+	}
+	if err := a.Spin("s1", inInvite, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Spin("s1", inAnswer, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if inst.StateName() != "talking" || inst.Data != "bob" {
+		t.Fatalf("unexpected state %s, data %v", inst.StateName(), inst.Data)
+	}
+	if err := a.Spin("s1", inBye, nil); err != nil {
+		t.Fatal(err)
+	}
+	//进入终止状态之后实例被删除
+	if a.Get("s1") != nil || a.Len() != 0 {
+		t.Fatal("instance should be removed in final state")
+	}
+	if err := a.Spin("s1", inBye, nil); !errors.Is(err, ErrFSMInstanceNotFound) {
+		t.Fatalf("expect not found, got %v", err)
+	}
+	if strings.Join(events, ",") != "enter ringing,exit ringing,enter closed" {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if h := inst.History(); len(h) != 3 || h[0].From != sessIdle || h[2].To != sessClosed {
+		t.Fatalf("unexpected history %v", h)
+	}
+	if s := inst.HistoryString(); !strings.Contains(s, "ringing -(1)-> talking") {
+		t.Fatalf("unexpected history %s", s)
+	}
+}
+
+func TestFSMActor_Timeout(t *testing.T) {
+	clock := NewMockClock(time.Now())
+	g := NewGoroutineMixInWithClock(clock)
+	var events []string
+	a := newSessionActor(t, g, &events)
+	a.SetHistorySize(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		g.Run(ctx)
+		close(done)
+	}()
+	g.Register("spawn", func(args []any) {
+		a.Spawn(args[0], nil)
+		a.Fire(args[0], inInvite, nil)
+	})
+	g.Register("state", func(args []any) any {
+		if inst := a.Get(args[0]); inst != nil {
+			return inst.State()
+		}
+		return -1
+	})
+	if err := g.Call0("spawn", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Call0("spawn", "s2"); err != nil {
+		t.Fatal(err)
+	}
+	a.Fire("s2", inAnswer, nil)
+	if st, _ := g.Call1("state", "s2"); st != sessTalking {
+		t.Fatalf("expect talking, got %v", st)
+	}
+	clock.Advance(time.Minute)
+	if st, _ := g.Call1("state", "s1"); st != -1 {
+		t.Fatalf("s1 should be closed by timeout, got %v", st)
+	}
+	//离开状态之后超时不再触发
+	if st, _ := g.Call1("state", "s2"); st != sessTalking {
+		t.Fatalf("expect talking, got %v", st)
+	}
+	cancel()
+	<-done
+}
+
+func TestNewFSMActor_Invalid(t *testing.T) {
+	g := NewGoroutineMixIn()
+	if _, err := NewFSMActor(g, "a", FSMState{Index: 0}, FSMState{Index: 0}); !errors.As(err, new(fsm.ClashingStateError)) {
+		t.Fatalf("expect clashing state, got %v", err)
+	}
+	_, err := NewFSMActor(g, "b", FSMState{Index: 0, Transitions: map[fsm.Input]FSMTransition{0: {State: 1}}})
+	if !errors.As(err, new(fsm.ImpossibleStateError)) {
+		t.Fatalf("expect impossible state, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package module
 import (
 	"context"
 	"github.com/YiuTerran/go-common/base/structs/rpc"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,16 +18,33 @@ type GoroutineMixIn struct {
 }
 
 func NewGoroutineMixIn() *GoroutineMixIn {
+	return NewGoroutineMixInWithClock(RealClock)
+}
+
+// NewGoroutineMixInWithClock 定时器使用指定的时钟，测试时可以传入MockClock
+func NewGoroutineMixInWithClock(clock Clock) *GoroutineMixIn {
 	var s GoroutineMixIn
 	s.g = NewCallbackChn()
-	s.dispatcher = NewDispatcher()
+	s.dispatcher = NewDispatcherWithClock(clock)
 	s.rpcClient = NewRpcClient(false)
 	s.RpcServer = NewRpcServer()
 	return &s
 }
 
 func (s *GoroutineMixIn) Run(ctx context.Context) {
+	s.dispatcher.loopStarted()
+	defer s.dispatcher.loopExited()
+	var pl priorityLoop
 	for {
+		if ctx.Err() == nil && (pl.pollStarvedLow(s.RpcServer) || pl.pollHigh(s.RpcServer)) {
+			continue
+		}
+		//其他消息都处理完了才处理低优先级的请求，nil chan不会被选中
+		//请求队列被关闭(RpcServer.Close)时退出主循环
+		var low <-chan *callInfo
+		if s.busy() == 0 {
+			low = s.RpcServer.chanLow.Out
+		}
 		select {
 		case <-ctx.Done():
 			s.RpcServer.Close()
@@ -39,7 +57,21 @@ func (s *GoroutineMixIn) Run(ctx context.Context) {
 			s.rpcClient.cb(ri)
 		case cb := <-s.g.ChanCb.Out:
 			s.g.Cb(cb)
-		case ci := <-s.RpcServer.ChanCall.Out:
+		case ci, ok := <-s.RpcServer.chanHigh.Out:
+			if !ok {
+				return
+			}
+			s.RpcServer.execIgnoreError(ci)
+		case ci, ok := <-s.RpcServer.ChanCall.Out:
+			if !ok {
+				return
+			}
+			s.RpcServer.execIgnoreError(ci)
+		case ci, ok := <-low:
+			if !ok {
+				return
+			}
+			pl.lowSkip = 0
 			s.RpcServer.execIgnoreError(ci)
 		case t := <-s.dispatcher.ChanTimer.Out:
 			t.Cb()
@@ -47,24 +79,41 @@ func (s *GoroutineMixIn) Run(ctx context.Context) {
 	}
 }
 
+// busy 除了低优先级请求之外还有多少消息等待处理
+func (s *GoroutineMixIn) busy() int {
+	return s.rpcClient.chanAsyncRet.Len() + s.g.ChanCb.Len() + s.RpcServer.chanHigh.Len() +
+		s.RpcServer.ChanCall.Len() + s.dispatcher.ChanTimer.Len()
+}
+
 func (s *GoroutineMixIn) PendingCallSize() int {
-	return s.g.pendingGo + s.rpcClient.pendingAsyncCall
+	return int(atomic.LoadInt32(&s.g.pendingGo) + atomic.LoadInt32(&s.rpcClient.pendingAsyncCall))
 }
 
 func (s *GoroutineMixIn) DispatcherSize() int {
 	return s.dispatcher.ChanTimer.Len()
 }
 
+// ChanCallSize 所有优先级的rpc请求数
 func (s *GoroutineMixIn) ChanCallSize() int {
-	return s.ChanCall.Len()
+	return s.QueueLen()
+}
+
+// Drained 队列中的请求都已经处理完毕，配合Drain使用
+func (s *GoroutineMixIn) Drained() bool {
+	return s.QueueLen() == 0
+}
+
+// Clock 模块定时器使用的时钟，需要当前时间时应该用Clock().Now()代替time.Now()
+func (s *GoroutineMixIn) Clock() Clock {
+	return s.dispatcher.clock
 }
 
 func (s *GoroutineMixIn) AfterFunc(d time.Duration, cb func()) *Timer {
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
-func (s *GoroutineMixIn) CronFunc(cronExpr *CronExpr, cb func()) *Cron {
-	return s.dispatcher.CronFunc(cronExpr, cb)
+func (s *GoroutineMixIn) CronFunc(cronExpr *CronExpr, cb func(), jitter ...time.Duration) *Cron {
+	return s.dispatcher.CronFunc(cronExpr, cb, jitter...)
 }
 
 func (s *GoroutineMixIn) Go(f func(), cb func()) {
@@ -0,0 +1,201 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"sync/atomic"
+)
+
+/**  模块的有界邮箱，限制RpcServer队列中等待执行的请求数量，防止慢模块把内存撑爆
+**/
+
+// AdmissionPolicy 邮箱满时对新请求的处理方式
+type AdmissionPolicy int
+
+const (
+	// MailboxReject 直接拒绝新请求，同步调用返回ErrMailboxFull，Go丢弃并记录日志
+	MailboxReject AdmissionPolicy = iota
+	// MailboxBlock 阻塞调用方直到邮箱有空位，同步调用受ctx控制
+	MailboxBlock
+	// MailboxShedOldest 丢弃队列中最早的请求，被丢弃的同步调用返回ErrMailboxShed
+	// 高优先级的请求不会被丢弃，队列中都是高优先级的请求时同MailboxBlock
+	MailboxShedOldest
+)
+
+var (
+	// ErrMailboxFull 邮箱已满，请求被拒绝
+	ErrMailboxFull = errors.New("chanrpc mailbox full")
+	// ErrMailboxShed 请求在队列中等待时被更新的请求挤掉了
+	ErrMailboxShed = errors.New("chanrpc call shed from mailbox")
+)
+
+// MailboxConfig 邮箱配置
+type MailboxConfig struct {
+	// Capacity 最多有多少个请求在队列中等待，<=0表示不限制
+	Capacity int
+	Policy   AdmissionPolicy
+	// HighWatermark 队列长度达到该值时回调OnHighWatermark，回落到一半以下之后才会再次触发
+	// 为0时默认是Capacity的80%
+	HighWatermark int
+	// OnHighWatermark 在投递请求的协程中执行，不要阻塞
+	OnHighWatermark func(queued int)
+}
+
+// MailboxStats 邮箱的统计
+type MailboxStats struct {
+	Queued   int
+	Capacity int
+	// Rejected 因为邮箱满被拒绝的请求数
+	Rejected int64
+	// Shed 被挤掉的请求数
+	Shed int64
+}
+
+// SetMailbox 设置邮箱容量和满时的处理方式，需要在模块运行之前调用
+func (s *RpcServer) SetMailbox(cfg MailboxConfig) {
+	if cfg.Capacity > 0 {
+		s.slots = make(chan struct{}, cfg.Capacity)
+		if cfg.HighWatermark <= 0 {
+			cfg.HighWatermark = cfg.Capacity * 8 / 10
+		}
+	} else {
+		s.slots = nil
+	}
+	s.mailbox = cfg
+}
+
+// MailboxStats 邮箱的统计，goroutine safe
+func (s *RpcServer) MailboxStats() MailboxStats {
+	return MailboxStats{
+		Queued:   int(atomic.LoadInt64(&s.queued)),
+		Capacity: s.mailbox.Capacity,
+		Rejected: atomic.LoadInt64(&s.rejected),
+		Shed:     atomic.LoadInt64(&s.shed),
+	}
+}
+
+// enqueue 请求进入对应优先级的队列，所有投递到模块的请求都要经过这里
+func (s *RpcServer) enqueue(ctx context.Context, ci *callInfo) error {
+	if !ci.unlimited {
+		if err := s.admit(ctx, ci.id); err != nil {
+			return err
+		}
+	}
+	n := atomic.AddInt64(&s.queued, 1)
+	sent := false
+	defer func() {
+		//没有放入队列(超时、队列已经关闭导致panic等)时释放位置
+		if !sent {
+			s.dequeue(ci)
+		}
+	}()
+	s.checkWatermark(n)
+	select {
+	case s.lane(ci.priority).In <- ci:
+		sent = true
+		return nil
+	case <-ctx.Done():
+		return &CallTimeoutError{Id: ci.id, Err: ctx.Err()}
+	}
+}
+
+func (s *RpcServer) admit(ctx context.Context, id any) error {
+	if s.slots == nil {
+		return nil
+	}
+	switch s.mailbox.Policy {
+	case MailboxBlock:
+		select {
+		case s.slots <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return &CallTimeoutError{Id: id, Err: ctx.Err()}
+		}
+	case MailboxShedOldest:
+		return s.admitShedOldest(ctx, id)
+	default:
+		select {
+		case s.slots <- struct{}{}:
+			return nil
+		default:
+			atomic.AddInt64(&s.rejected, 1)
+			return fmt.Errorf("function id %v: %w", id, ErrMailboxFull)
+		}
+	}
+}
+
+// admitShedOldest 丢弃低优先级、普通优先级队列中最早的请求来腾出位置，受ctx控制
+func (s *RpcServer) admitShedOldest(ctx context.Context, id any) error {
+	low, normal := s.chanLow.Out, s.ChanCall.Out
+	for {
+		select {
+		case s.slots <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return &CallTimeoutError{Id: id, Err: ctx.Err()}
+		default:
+		}
+		//优先丢弃低优先级的请求
+		var old *callInfo
+		ok := true
+		select {
+		case old, ok = <-low:
+		default:
+			//都是高优先级的请求，或者请求还在队列内部的缓冲中转移，等待空位或者可以丢弃的请求
+			select {
+			case s.slots <- struct{}{}:
+				return nil
+			case <-ctx.Done():
+				return &CallTimeoutError{Id: id, Err: ctx.Err()}
+			case old, ok = <-low:
+			case old, ok = <-normal:
+			}
+		}
+		if !ok {
+			return errors.New("chanrpc rpcServer closed")
+		}
+		if !s.shedCall(old) {
+			//内部的投递任务不占位置，放回之后只等待空位，避免反复取出同一个任务
+			low, normal = nil, nil
+		}
+	}
+}
+
+// shedCall 丢弃请求，返回是否释放了邮箱的位置
+func (s *RpcServer) shedCall(ci *callInfo) bool {
+	if ci.unlimited {
+		//内部的投递任务不能丢，放回队尾
+		s.lane(ci.priority).In <- ci
+		return false
+	}
+	s.dequeue(ci)
+	atomic.AddInt64(&s.shed, 1)
+	if ci.chanRet == nil {
+		log.Warn("function id %v: %v", ci.id, ErrMailboxShed)
+	}
+	_ = s.ret(ci, &retInfo{err: fmt.Errorf("function id %v: %w", ci.id, ErrMailboxShed)})
+	return true
+}
+
+// dequeue 请求离开队列，释放邮箱的位置
+func (s *RpcServer) dequeue(ci *callInfo) {
+	n := atomic.AddInt64(&s.queued, -1)
+	if s.slots != nil && !ci.unlimited {
+		<-s.slots
+	}
+	if n < int64(s.mailbox.HighWatermark/2) {
+		atomic.StoreInt32(&s.aboveWatermark, 0)
+	}
+}
+
+func (s *RpcServer) checkWatermark(n int64) {
+	hw := s.mailbox.HighWatermark
+	if hw <= 0 || s.mailbox.OnHighWatermark == nil || n < int64(hw) {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&s.aboveWatermark, 0, 1) {
+		s.mailbox.OnHighWatermark(int(n))
+	}
+}
@@ -0,0 +1,158 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMailbox_Reject(t *testing.T) {
+	s := NewRpcServer()
+	var watermark int
+	s.SetMailbox(MailboxConfig{Capacity: 2, Policy: MailboxReject, OnHighWatermark: func(queued int) {
+		watermark = queued
+	}})
+	s.Register("f", func([]any) {})
+	s.Go("f")
+	s.Go("f")
+	s.Go("f")
+	if st := s.MailboxStats(); st.Queued != 2 || st.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if watermark != 1 {
+		t.Fatalf("expect high watermark at 1, got %d", watermark)
+	}
+	if err := s.Call0("f"); !errors.Is(err, ErrMailboxFull) {
+		t.Fatalf("expect mailbox full, got %v", err)
+	}
+	s.execIgnoreError(<-s.ChanCall.Out)
+	if st := s.MailboxStats(); st.Queued != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestMailbox_Block(t *testing.T) {
+	s := NewRpcServer()
+	s.SetMailbox(MailboxConfig{Capacity: 1, Policy: MailboxBlock})
+	s.Register("f", func([]any) {})
+	s.Go("f")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var te *CallTimeoutError
+	if err := s.Call0Context(ctx, "f"); !errors.As(err, &te) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.Call0("f")
+	}()
+	select {
+	case <-done:
+		t.Fatal("call should block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	go func() {
+		for ci := range s.ChanCall.Out {
+			s.execIgnoreError(ci)
+		}
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMailbox_ShedOldest(t *testing.T) {
+	s := NewRpcServer()
+	s.SetMailbox(MailboxConfig{Capacity: 1, Policy: MailboxShedOldest})
+	var executed []int
+	s.Register("f", func(args []any) {
+		executed = append(executed, args[0].(int))
+	})
+	s.Go("f", 1)
+	s.Go("f", 2)
+	if st := s.MailboxStats(); st.Queued != 1 || st.Shed != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	s.execIgnoreError(<-s.ChanCall.Out)
+	if len(executed) != 1 || executed[0] != 2 {
+		t.Fatalf("expect only newest executed, got %v", executed)
+	}
+}
+
+func TestMailbox_BlockGoContext(t *testing.T) {
+	s := NewRpcServer()
+	s.SetMailbox(MailboxConfig{Capacity: 1, Policy: MailboxBlock})
+	s.Register("f", func([]any) {})
+	s.Go("f")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		s.GoContext(ctx, "f")
+		close(done)
+	}()
+	//ctx取消之后不再阻塞，请求被丢弃
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("GoContext should return after ctx canceled")
+	}
+	if st := s.MailboxStats(); st.Queued != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestMailbox_ShedOldestHigh(t *testing.T) {
+	s := NewRpcServer()
+	s.SetMailbox(MailboxConfig{Capacity: 1, Policy: MailboxShedOldest})
+	s.Register("h", func([]any) {})
+	s.SetPriority("h", PriorityHigh)
+	s.Register("f", func([]any) {})
+	s.Go("h")
+	//没有可以丢弃的请求时等待空位，ctx超时之后返回
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var te *CallTimeoutError
+	if err := s.Call0Context(ctx, "f"); !errors.As(err, &te) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.Call0("f")
+	}()
+	select {
+	case <-done:
+		t.Fatal("call should block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.execIgnoreError(<-s.chanHigh.Out)
+	go func() {
+		for ci := range s.ChanCall.Out {
+			s.execIgnoreError(ci)
+		}
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := s.MailboxStats(); st.Shed != 0 || st.Queued != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestMailbox_EnqueueClosed(t *testing.T) {
+	s := NewRpcServer()
+	s.SetMailbox(MailboxConfig{Capacity: 1, Policy: MailboxReject})
+	s.Register("f", func([]any) {})
+	s.Close()
+	//队列已经关闭，投递失败之后不能占着位置
+	for i := 0; i < 3; i++ {
+		if err := s.Call0("f"); err == nil {
+			t.Fatal("expect error after close")
+		}
+		s.Go("f")
+	}
+	if st := s.MailboxStats(); st.Queued != 0 || st.Rejected != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
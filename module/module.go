@@ -3,6 +3,7 @@ package module
 import (
 	"context"
 	"github.com/YiuTerran/go-common/base/structs/rpc"
+	"time"
 )
 
 //Module 是抽象的运行模块
@@ -23,3 +24,32 @@ type Module interface {
 	// 可以使用GoroutineMixIn作为默认实现
 	RPC() rpc.IServer
 }
+
+// Dependent 可选接口，声明模块依赖的其他模块(Name)
+// server会保证被依赖的模块先初始化、后销毁
+type Dependent interface {
+	DependsOn() []string
+}
+
+// HealthChecker 可选接口，模块自定义健康检查
+// 会在探针请求的协程里调用，需要注意并发安全，且不要阻塞
+type HealthChecker interface {
+	// Alive 模块是否存活(比如主循环是否卡住)，返回error时liveness检查失败
+	Alive() error
+	// Ready 模块是否可以对外服务，返回error时readiness检查失败
+	Ready() error
+}
+
+// Drainer 可选接口，模块关闭前先停止接收新的请求，并处理完已经接收的请求
+// GoroutineMixIn已经实现了这个接口
+type Drainer interface {
+	// Drain 停止接收新的请求
+	Drain()
+	// Drained 已经接收的请求是否都已经处理完毕
+	Drained() bool
+}
+
+// DrainTimeouter 可选接口，声明模块关闭时最多等待多久，超时之后server不再等待该模块
+type DrainTimeouter interface {
+	DrainTimeout() time.Duration
+}
@@ -0,0 +1,117 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/structs/chanx"
+	"runtime"
+)
+
+/**  rpc请求的优先级，模块主循环优先处理高优先级的请求
+  *  一般把心跳、控制命令设置为高优先级，把大量的、可以延迟的通知设置为低优先级
+**/
+
+// Priority 请求的优先级，默认是PriorityNormal
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// maxPriorityBurst 连续处理这么多个高优先级请求之后，让其他消息处理一次；
+// 低优先级请求连续被跳过这么多次之后，也会被处理一次，防止饿死
+const maxPriorityBurst = 64
+
+// lane 优先级对应的队列，普通优先级就是ChanCall
+func (s *RpcServer) lane(p Priority) *chanx.UnboundedChan[*callInfo] {
+	switch {
+	case p > PriorityNormal:
+		return s.chanHigh
+	case p < PriorityNormal:
+		return s.chanLow
+	}
+	return s.ChanCall
+}
+
+// SetPriority 设置id对应请求的优先级，Go和同步调用都会生效
+// 和Register一样，需要在模块运行之前调用
+func (s *RpcServer) SetPriority(id any, p Priority) {
+	if _, ok := s.functions[id]; !ok {
+		panic(fmt.Sprintf("function id %v: not registered", id))
+	}
+	s.priorities[id] = p
+}
+
+func (s *RpcServer) priorityOf(id any) Priority {
+	return s.priorities[id]
+}
+
+// GoPriority 以指定的优先级异步执行，忽略SetPriority的设置，goroutine safe
+func (s *RpcServer) GoPriority(p Priority, id any, args ...any) {
+	s.goWithContext(context.Background(), p, id, args)
+}
+
+// QueueLen 所有优先级队列中等待执行的请求数
+func (s *RpcServer) QueueLen() int {
+	return s.chanHigh.Len() + s.ChanCall.Len() + s.chanLow.Len()
+}
+
+// priorityLoop 模块主循环中优先级的调度状态，只在模块协程中使用
+type priorityLoop struct {
+	highBurst int
+	lowSkip   int
+}
+
+// pollHigh 有高优先级请求时执行一个，返回是否执行了
+func (pl *priorityLoop) pollHigh(s *RpcServer) bool {
+	if pl.highBurst >= maxPriorityBurst {
+		pl.highBurst = 0
+		return false
+	}
+	ci, ok := tryRecv(s.chanHigh)
+	if !ok {
+		pl.highBurst = 0
+		return false
+	}
+	pl.highBurst++
+	s.execIgnoreError(ci)
+	return true
+}
+
+// pollStarvedLow 低优先级请求被跳过太多次时执行一个，返回是否执行了
+func (pl *priorityLoop) pollStarvedLow(s *RpcServer) bool {
+	if s.chanLow.Len() == 0 {
+		pl.lowSkip = 0
+		return false
+	}
+	pl.lowSkip++
+	if pl.lowSkip < maxPriorityBurst {
+		return false
+	}
+	ci, ok := tryRecv(s.chanLow)
+	if !ok {
+		return false
+	}
+	pl.lowSkip = 0
+	s.execIgnoreError(ci)
+	return true
+}
+
+// tryRecv 非阻塞读取；请求还在队列内部的缓冲中转移时，让出cpu给转移的协程之后再试一次
+// 队列已经关闭时返回false
+func tryRecv(ch *chanx.UnboundedChan[*callInfo]) (*callInfo, bool) {
+	for i := 0; i < 2; i++ {
+		select {
+		case ci, ok := <-ch.Out:
+			return ci, ok
+		default:
+		}
+		if ch.Len() == 0 {
+			break
+		}
+		runtime.Gosched()
+	}
+	return nil, false
+}
@@ -0,0 +1,106 @@
+package module
+
+import (
+	"context"
+	"github.com/YiuTerran/go-common/base/structs/chanx"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// settle 等待请求从队列内部的缓冲转移到Out，避免调度顺序受转移协程的影响
+func settle(ch *chanx.UnboundedChan[*callInfo], n int) {
+	for len(ch.Out) < n {
+		runtime.Gosched()
+	}
+}
+
+func TestPriority(t *testing.T) {
+	g := NewGoroutineMixIn()
+	var order []string
+	for _, id := range []string{"high", "normal", "low"} {
+		id := id
+		g.Register(id, func([]any) {
+			order = append(order, id)
+		})
+	}
+	g.SetPriority("high", PriorityHigh)
+	g.SetPriority("low", PriorityLow)
+	g.RpcServer.Go("low")
+	g.RpcServer.Go("normal")
+	g.RpcServer.Go("normal")
+	g.GoPriority(PriorityHigh, "normal")
+	g.RpcServer.Go("high")
+
+	done := make(chan struct{})
+	g.Register("done", func([]any) { close(done) })
+	g.GoPriority(PriorityLow, "done")
+	settle(g.chanHigh, 2)
+	settle(g.ChanCall, 2)
+	settle(g.chanLow, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+	<-done
+	expect := []string{"normal", "high", "normal", "normal", "low"}
+	if len(order) != len(expect) {
+		t.Fatalf("unexpected order %v", order)
+	}
+	for i := range expect {
+		if order[i] != expect[i] {
+			t.Fatalf("unexpected order %v", order)
+		}
+	}
+}
+
+func TestPriority_Starvation(t *testing.T) {
+	g := NewGoroutineMixIn()
+	var order []string
+	g.Register("high", func([]any) { order = append(order, "high") })
+	g.Register("low", func([]any) { order = append(order, "low") })
+	g.SetPriority("high", PriorityHigh)
+	g.SetPriority("low", PriorityLow)
+	g.RpcServer.Go("low")
+	for i := 0; i < maxPriorityBurst*3; i++ {
+		g.RpcServer.Go("high")
+	}
+	done := make(chan struct{})
+	g.Register("done", func([]any) { close(done) })
+	g.GoPriority(PriorityLow, "done")
+	settle(g.chanHigh, maxPriorityBurst*3)
+	settle(g.chanLow, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		g.Run(ctx)
+		close(exited)
+	}()
+	<-done
+	cancel()
+	<-exited
+	for i, o := range order {
+		if o == "low" {
+			if i > maxPriorityBurst*2 {
+				t.Fatalf("low priority call starved until %d", i)
+			}
+			return
+		}
+	}
+	t.Fatal("low priority call not executed")
+}
+
+func TestPriority_Closed(t *testing.T) {
+	g := NewGoroutineMixIn()
+	g.RpcServer.Close()
+	exited := make(chan struct{})
+	go func() {
+		g.Run(context.Background())
+		close(exited)
+	}()
+	//队列关闭之后主循环退出，不会一直读到nil
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("run should exit after queues closed")
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"github.com/YiuTerran/go-common/module"
+	"net/http"
+	"path"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**  模块的管理接口，查看模块状态、重载/停止/启动单个模块、导出模块的协程栈
+  *  AdminHandler不依赖挂载的路径，可以挂在gin(ginutil.EnableModuleAdmin)或者debugutil.LaunchHttpServer的mux上
+**/
+
+// moduleLabel 模块协程的pprof标签
+const moduleLabel = "module"
+
+// queueSizer GoroutineMixIn实现了这些方法
+type queueSizer interface {
+	PendingCallSize() int
+	ChanCallSize() int
+	DispatcherSize() int
+}
+
+// drainingChecker GoroutineMixIn实现了这个方法，Drain之后就不能再使用了
+type drainingChecker interface {
+	Draining() bool
+}
+
+// ModuleInfo 单个模块的运行信息
+type ModuleInfo struct {
+	Name     string    `json:"name"`
+	Tags     []string  `json:"tags,omitempty"`
+	State    State     `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	LoadedAt time.Time `json:"loaded_at"`
+	// 以下字段仅在模块使用GoroutineMixIn时有效
+	PendingCallSize int `json:"pending_call_size"`
+	ChanCallSize    int `json:"chan_call_size"`
+	DispatcherSize  int `json:"dispatcher_size"`
+}
+
+// ModulesReport 所有模块的运行信息
+type ModulesReport struct {
+	Static bool `json:"static"`
+	// LastReload 最近一次热加载的时间，静态模式下为零值
+	LastReload time.Time    `json:"last_reload"`
+	Modules    []ModuleInfo `json:"modules"`
+}
+
+// Modules 所有模块的运行信息，按名称排序
+func (mgr *Manager) Modules() *ModulesReport {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	report := &ModulesReport{
+		Static:     mgr.staticMode,
+		LastReload: mgr.lastReload,
+		Modules:    make([]ModuleInfo, 0, len(mgr.mods)),
+	}
+	for name, m := range mgr.mods {
+		state, reason := m.getState()
+		info := ModuleInfo{
+			Name:     name,
+			Tags:     m.mi.Tags(),
+			State:    state,
+			Error:    reason,
+			LoadedAt: m.loadedAt,
+		}
+		if qs, ok := m.mi.(queueSizer); ok {
+			info.PendingCallSize = qs.PendingCallSize()
+			info.ChanCallSize = qs.ChanCallSize()
+			info.DispatcherSize = qs.DispatcherSize()
+		}
+		report.Modules = append(report.Modules, info)
+	}
+	sort.Slice(report.Modules, func(i, j int) bool {
+		return report.Modules[i].Name < report.Modules[j].Name
+	})
+	return report
+}
+
+// ReloadModules 重载指定的模块，只处理GetModuleActions返回结果中这些模块的变化，仅在HotRun模式下有效
+// 不传names时等同于Reload
+func (mgr *Manager) ReloadModules(names ...string) error {
+	if mgr.isStatic() {
+		return errors.New("server running in static mode")
+	}
+	if len(names) == 0 {
+		mgr.Reload()
+		return nil
+	}
+	mgr.lock.RLock()
+	getMods := mgr.getMods
+	mgr.lock.RUnlock()
+	if getMods == nil {
+		return errors.New("server not started")
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = false
+	}
+	actions := make(map[Action][]module.Module)
+	for action, mis := range getMods() {
+		for _, mi := range mis {
+			if _, ok := wanted[mi.Name()]; ok {
+				actions[action] = append(actions[action], mi)
+				wanted[mi.Name()] = true
+			}
+		}
+	}
+	if err := mgr.reloadByAction(actions); err != nil {
+		return err
+	}
+	var missing []string
+	for name, found := range wanted {
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("no action for modules: %v", missing)
+	}
+	return nil
+}
+
+// StopModule 停止单个模块，模块仍然保留在Manager中，状态为StateSuspended，可以用StartModule重新启动
+// 不会检查其他模块是否依赖它
+func (mgr *Manager) StopModule(name string) error {
+	mgr.opLock.Lock()
+	defer mgr.opLock.Unlock()
+	mgr.lock.RLock()
+	m, ok := mgr.mods[name]
+	mgr.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("module %s not found", name)
+	}
+	if state, _ := m.getState(); state == StateSuspended {
+		return nil
+	}
+	mgr.stopMod(m, time.Time{})
+	m.setState(StateSuspended, "")
+	log.Info("module suspended: %s", name)
+	return nil
+}
+
+// StartModule 重新启动被StopModule停止的模块，会再次调用OnInit
+// 停止时GoroutineMixIn已经Drain并关闭，模块需要在OnInit中重新创建它，否则返回错误，模块仍然是停止状态
+// OnInit panic时返回错误，模块标记为崩溃
+func (mgr *Manager) StartModule(name string) error {
+	mgr.opLock.Lock()
+	defer mgr.opLock.Unlock()
+	mgr.lock.RLock()
+	old, ok := mgr.mods[name]
+	mgr.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("module %s not found", name)
+	}
+	if state, _ := old.getState(); state != StateSuspended {
+		return fmt.Errorf("module %s is %s, only suspended module can be started", name, state)
+	}
+	m := mgr.newMod(old.mi)
+	if err := m.init(); err != nil {
+		old.setState(StateCrashed, err.Error())
+		return fmt.Errorf("start module %s failed: %w", name, err)
+	}
+	if dc, ok := m.mi.(drainingChecker); ok && dc.Draining() {
+		m.mi.OnDestroy()
+		return fmt.Errorf("module %s can not be restarted, create GoroutineMixIn in OnInit instead of constructor", name)
+	}
+	mgr.lock.Lock()
+	mgr.mods[name] = m
+	for i := range mgr.ordered {
+		if mgr.ordered[i] == old {
+			mgr.ordered[i] = m
+		}
+	}
+	mgr.lock.Unlock()
+	m.wg.Add(1)
+	go m.run()
+	log.Info("module resumed: %s", name)
+	return nil
+}
+
+// init 调用模块的OnInit，panic时返回错误而不是让管理接口的调用方崩溃
+func (m *mod) init() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.PanicStack(fmt.Sprintf("panic when init module %s", m.mi.Name()), r)
+			err = fmt.Errorf("panic when init: %v", r)
+		}
+	}()
+	m.mi.OnInit()
+	return nil
+}
+
+// DumpStacks 导出模块的协程栈，包括模块主循环及其创建的协程，name为空时导出所有协程
+// OnInit中创建的协程不在模块主循环中，不会被导出
+func (mgr *Manager) DumpStacks(name string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return buf.Bytes(), nil
+	}
+	label := fmt.Sprintf("%q:%q", moduleLabel, name)
+	var out bytes.Buffer
+	//debug=1的格式中，每组协程栈以空行分隔，标签在"# labels: "开头的行
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var record []string
+	matched := false
+	flush := func() {
+		if matched {
+			out.WriteString(strings.Join(record, "\n"))
+			out.WriteString("\n\n")
+		}
+		record, matched = record[:0], false
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "# labels: ") && strings.Contains(line, label) {
+			matched = true
+		}
+		record = append(record, line)
+	}
+	flush()
+	return out.Bytes(), scanner.Err()
+}
+
+// AdminHandler 模块管理接口，按请求路径的最后一段分发：
+//
+//	GET  .../            所有模块的运行信息
+//	POST .../reload?name=a&name=b  重载模块，不带name时重载所有模块
+//	POST .../stop?name=a           停止模块
+//	POST .../start?name=a          启动被停止的模块
+//	GET  .../stacks?name=a         导出模块的协程栈，不带name时导出所有协程
+func (mgr *Manager) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["name"]
+		var err error
+		switch action := path.Base(r.URL.Path); action {
+		case "reload", "stop", "start":
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			switch {
+			case action == "reload":
+				err = mgr.ReloadModules(names...)
+			case len(names) == 0:
+				err = errors.New("name required")
+			default:
+				for _, name := range names {
+					if action == "stop" {
+						err = mgr.StopModule(name)
+					} else {
+						err = mgr.StartModule(name)
+					}
+					if err != nil {
+						break
+					}
+				}
+			}
+		case "stacks":
+			var bs []byte
+			if bs, err = mgr.DumpStacks(r.URL.Query().Get("name")); err == nil {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				_, _ = w.Write(bs)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(mgr.Modules())
+	})
+}
+
+// Modules 所有模块的运行信息，按名称排序
+func Modules() *ModulesReport {
+	return defaultManager.Modules()
+}
+
+// ReloadModules 重载指定的模块，仅在HotRun模式下有效
+func ReloadModules(names ...string) error {
+	return defaultManager.ReloadModules(names...)
+}
+
+// StopModule 停止单个模块，可以用StartModule重新启动
+func StopModule(name string) error {
+	return defaultManager.StopModule(name)
+}
+
+// StartModule 重新启动被StopModule停止的模块
+func StartModule(name string) error {
+	return defaultManager.StartModule(name)
+}
+
+// DumpStacks 导出模块的协程栈，name为空时导出所有协程
+func DumpStacks(name string) ([]byte, error) {
+	return defaultManager.DumpStacks(name)
+}
+
+// AdminHandler 模块管理接口
+func AdminHandler() http.Handler {
+	return defaultManager.AdminHandler()
+}
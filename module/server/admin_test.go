@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/YiuTerran/go-common/module"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_Admin(t *testing.T) {
+	mgr := NewManager()
+	go mgr.Run(&depMod{name: "a"}, &depMod{name: "b", deps: []string{"a"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		mgr.Close()
+	}()
+	h := mgr.AdminHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/modules", nil))
+	var report ModulesReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Static || len(report.Modules) != 2 || report.Modules[0].Name != "a" {
+		t.Fatalf("unexpected report %s", w.Body.String())
+	}
+
+	stacks, err := mgr.DumpStacks("a")
+	if err != nil || !strings.Contains(string(stacks), "(*depMod).Run") {
+		t.Fatalf("unexpected stacks %s, %v", stacks, err)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/modules/stop?name=a", nil))
+	if w.Code != http.StatusOK || mgr.Modules().Modules[0].State != StateSuspended {
+		t.Fatalf("stop failed: %s", w.Body.String())
+	}
+	//手动停止的模块不影响liveness
+	if !mgr.Liveness().Ok || mgr.Readiness().Ok {
+		t.Fatal("suspended module should only fail readiness")
+	}
+	if stacks, _ = mgr.DumpStacks("a"); len(stacks) != 0 {
+		t.Fatalf("suspended module should have no goroutine, got %s", stacks)
+	}
+	if err = mgr.StartModule("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//静态模式不能重载
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/modules/reload?name=a", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expect bad request, got %d", w.Code)
+	}
+}
+
+func TestManager_ReloadModules(t *testing.T) {
+	mgr := NewManager()
+	version := 0
+	go mgr.HotRun(func() map[Action][]module.Module {
+		version++
+		return map[Action][]module.Module{
+			Update: {&depMod{name: "a"}, &depMod{name: "b"}},
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+	before := mgr.Modules()
+	if err := mgr.ReloadModules("a", "c"); err == nil || !strings.Contains(err.Error(), "[c]") {
+		t.Fatalf("expect no action for c, got %v", err)
+	}
+	after := mgr.Modules()
+	if !after.Modules[0].LoadedAt.After(before.Modules[0].LoadedAt) {
+		t.Fatal("module a should be reloaded")
+	}
+	if !after.Modules[1].LoadedAt.Equal(before.Modules[1].LoadedAt) {
+		t.Fatal("module b should not be reloaded")
+	}
+}
+
+func TestManager_StartModule(t *testing.T) {
+	mgr := NewManager()
+	//crashMod在构造函数中创建GoroutineMixIn，lazyMod在OnInit中创建
+	eager, lazy := newCrashMod("eager", module.PanicRestart), &lazyMod{}
+	go mgr.Run(eager, lazy)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+	for _, name := range []string{"eager", "lazy"} {
+		if err := mgr.StopModule(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//已经Drain的GoroutineMixIn不能再次运行
+	if err := mgr.StartModule("eager"); err == nil {
+		t.Fatal("expect error when restarting a module with drained GoroutineMixIn")
+	}
+	if info := mgr.Modules().Modules[0]; info.Name != "eager" || info.State != StateSuspended {
+		t.Fatalf("module should stay suspended, got %+v", info)
+	}
+	if err := mgr.StartModule("lazy"); err != nil {
+		t.Fatal(err)
+	}
+	for mgr.Modules().Modules[1].State != StateRunning {
+		if ctx.Err() != nil {
+			t.Fatal("module not resumed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lazy.Draining() {
+		t.Fatal("restarted module should accept requests")
+	}
+}
+
+// initPanicMod 第一次之后的OnInit都会panic
+type initPanicMod struct {
+	lazyMod
+	inits int
+}
+
+func (m *initPanicMod) Name() string { return "init-panic" }
+func (m *initPanicMod) OnInit() {
+	m.inits++
+	if m.inits > 1 {
+		panic("init failed")
+	}
+	m.lazyMod.OnInit()
+}
+
+func TestManager_StartModulePanic(t *testing.T) {
+	mgr := NewManager()
+	m := &initPanicMod{}
+	go mgr.Run(m)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+	if err := mgr.StopModule("init-panic"); err != nil {
+		t.Fatal(err)
+	}
+	//OnInit的panic作为错误返回，模块标记为崩溃
+	if err := mgr.StartModule("init-panic"); err == nil || !strings.Contains(err.Error(), "init failed") {
+		t.Fatalf("expect init panic as error, got %v", err)
+	}
+	if info := mgr.Modules().Modules[0]; info.State != StateCrashed {
+		t.Fatalf("module should be crashed, got %+v", info)
+	}
+	if mgr.Liveness().Ok {
+		t.Fatal("liveness should fail after start failed")
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"github.com/samber/lo"
+	"github.com/YiuTerran/go-common/base/log"
+	"github.com/YiuTerran/go-common/module"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/**  根据配置热加载模块，配置中的每个key对应一个模块
+  *  配置变化时自动计算New/Update/Delete，配合HotRun使用：
+  *    cm := server.NewConfigModules(factories)
+  *    sv.WatchSection("modules", cm.Update) //nacos.SafeViper
+  *    server.HotRun(cm.Actions)
+  *  热加载成功之后才记录已经加载的配置，失败时下次配置变化或者Reload时重试
+**/
+
+// ModuleFactory 根据配置创建模块，key是配置中的key，conf是key对应的配置
+type ModuleFactory func(key string, conf any) (module.Module, error)
+
+// ConfigModules 配置和模块的对应关系，goroutine safe
+type ConfigModules struct {
+	mgr       *Manager
+	factories map[string]ModuleFactory
+
+	lock    sync.Mutex
+	section map[string]any           //最新的配置
+	applied map[string]any           //已经加载的模块对应的配置
+	loaded  map[string]module.Module //已经加载的模块
+	pending map[string]configChange  //Actions计算出来、还没有Commit的变化
+}
+
+// configChange 一个key的变化，mi为nil表示删除
+type configChange struct {
+	conf any
+	mi   module.Module
+	prev module.Module
+}
+
+// NewConfigModules factories的key就是配置中的key，不区分大小写(viper会把key转成小写)
+func (mgr *Manager) NewConfigModules(factories map[string]ModuleFactory) *ConfigModules {
+	cm := &ConfigModules{
+		mgr:       mgr,
+		factories: make(map[string]ModuleFactory, len(factories)),
+		applied:   make(map[string]any),
+		loaded:    make(map[string]module.Module),
+	}
+	for k, f := range factories {
+		cm.factories[strings.ToLower(k)] = f
+	}
+	mgr.onReloaded(cm.Commit)
+	return cm
+}
+
+// Update 更新配置，HotRun之后会触发一次热加载，可以直接作为配置变化的回调
+func (cm *ConfigModules) Update(section map[string]any) {
+	cp := make(map[string]any, len(section))
+	for k, v := range section {
+		cp[strings.ToLower(k)] = v
+	}
+	cm.lock.Lock()
+	cm.section = cp
+	cm.lock.Unlock()
+	cm.mgr.lock.RLock()
+	running := cm.mgr.getMods != nil && !cm.mgr.staticMode
+	cm.mgr.lock.RUnlock()
+	if !running {
+		return
+	}
+	//已经有重载在排队时不用再触发，重载时读取的是最新的配置
+	select {
+	case cm.mgr.reloadChn <- reloadSig:
+	default:
+	}
+}
+
+// Actions 对比最新的配置和已经加载的配置，计算模块的变化，可以直接作为HotRun的参数
+// 没有对应factory的key会被忽略；factory返回错误时保留旧模块，下次配置变化时再重试
+// 计算结果在Commit之后才会记录为已加载，Manager热加载成功之后会自动调用Commit，失败时下次重新计算
+func (cm *ConfigModules) Actions() map[Action][]module.Module {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	actions := make(map[Action][]module.Module)
+	cm.pending = make(map[string]configChange)
+	for key, mi := range cm.loaded {
+		if _, ok := cm.section[key]; !ok {
+			actions[Delete] = append(actions[Delete], mi)
+			cm.pending[key] = configChange{prev: mi}
+		}
+	}
+	for key, conf := range cm.section {
+		old, exists := cm.applied[key]
+		if exists && reflect.DeepEqual(old, conf) {
+			continue
+		}
+		factory, ok := cm.factories[key]
+		if !ok {
+			log.Warn("no module factory for config key %s, ignore", key)
+			continue
+		}
+		mi, err := factory(key, conf)
+		if err != nil {
+			log.Error("fail to create module for config key %s: %v", key, err)
+			continue
+		}
+		prev, ok := cm.loaded[key]
+		if !ok {
+			actions[New] = append(actions[New], mi)
+		} else if prev.Name() == mi.Name() {
+			actions[Update] = append(actions[Update], mi)
+		} else {
+			//模块改名了，先删除旧的
+			actions[Delete] = append(actions[Delete], prev)
+			actions[New] = append(actions[New], mi)
+		}
+		cm.pending[key] = configChange{conf: conf, mi: mi, prev: prev}
+	}
+	return actions
+}
+
+// Commit 热加载成功之后，把actions中的模块对应的配置记录为已加载
+// actions是最近一次Actions的返回值或者其中的一部分(比如ReloadModules只重载指定的模块)
+func (cm *ConfigModules) Commit(actions map[Action][]module.Module) {
+	applied := func(action Action, name string) bool {
+		return lo.ContainsBy(actions[action], func(mi module.Module) bool { return mi.Name() == name })
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	for key, c := range cm.pending {
+		switch {
+		case c.mi == nil:
+			if !applied(Delete, c.prev.Name()) {
+				continue
+			}
+			delete(cm.loaded, key)
+			delete(cm.applied, key)
+		case applied(New, c.mi.Name()) || applied(Update, c.mi.Name()):
+			cm.applied[key] = c.conf
+			cm.loaded[key] = c.mi
+		default:
+			continue
+		}
+		delete(cm.pending, key)
+	}
+}
+
+// Loaded 已经加载的模块，key是配置中的key
+func (cm *ConfigModules) Loaded() map[string]module.Module {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	resp := make(map[string]module.Module, len(cm.loaded))
+	for k, v := range cm.loaded {
+		resp[k] = v
+	}
+	return resp
+}
+
+// NewConfigModules 根据配置热加载模块，使用默认的Manager
+func NewConfigModules(factories map[string]ModuleFactory) *ConfigModules {
+	return defaultManager.NewConfigModules(factories)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/YiuTerran/go-common/module"
+	"testing"
+	"time"
+)
+
+func TestConfigModules_Actions(t *testing.T) {
+	fail := false
+	factory := func(key string, conf any) (module.Module, error) {
+		if fail {
+			return nil, errors.New("bad config")
+		}
+		return &depMod{name: key}, nil
+	}
+	cm := NewManager().NewConfigModules(map[string]ModuleFactory{"Gate": factory, "game": factory})
+	cm.Update(map[string]any{"gate": map[string]any{"addr": ":8080"}, "game": 1, "unknown": 1})
+	actions := cm.Actions()
+	if len(actions[New]) != 2 || len(actions[Update]) != 0 || len(actions[Delete]) != 0 {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	//没有Commit时下次还是同样的变化
+	if actions = cm.Actions(); len(actions[New]) != 2 || len(cm.Loaded()) != 0 {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	cm.Commit(actions)
+	//配置没变化
+	cm.Update(map[string]any{"gate": map[string]any{"addr": ":8080"}, "game": 1})
+	if actions = cm.Actions(); len(actions) != 0 {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	cm.Update(map[string]any{"gate": map[string]any{"addr": ":8081"}})
+	actions = cm.Actions()
+	if names(actions[Update]) != "gate" || names(actions[Delete]) != "game" || len(actions[New]) != 0 {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	cm.Commit(actions)
+	//创建失败时保留旧模块，下次再重试
+	fail = true
+	cm.Update(map[string]any{"gate": map[string]any{"addr": ":8082"}})
+	if actions = cm.Actions(); len(actions) != 0 || len(cm.Loaded()) != 1 {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	fail = false
+	if actions = cm.Actions(); names(actions[Update]) != "gate" {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+}
+
+func TestConfigModules_HotReload(t *testing.T) {
+	mgr := NewManager()
+	cm := mgr.NewConfigModules(map[string]ModuleFactory{
+		"a": func(key string, conf any) (module.Module, error) {
+			return &depMod{name: key}, nil
+		},
+		"b": func(key string, conf any) (module.Module, error) {
+			return &depMod{name: key, deps: []string{"a"}}, nil
+		},
+	})
+	cm.Update(map[string]any{"a": 1})
+	go mgr.HotRun(cm.Actions)
+	defer mgr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cm.Update(map[string]any{"a": 1, "b": 1})
+	for mgr.GetModuleByName("b") == nil {
+		if ctx.Err() != nil {
+			t.Fatal("module b not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cm.Update(map[string]any{"b": 1})
+	for mgr.GetModuleByName("a") != nil {
+		if ctx.Err() != nil {
+			t.Fatal("module a not deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfigModules_ReloadFailed(t *testing.T) {
+	mgr := NewManager()
+	deps := map[string][]string{"a": nil}
+	factory := func(key string, conf any) (module.Module, error) {
+		return &depMod{name: key, deps: deps[key]}, nil
+	}
+	cm := mgr.NewConfigModules(map[string]ModuleFactory{"a": factory, "b": factory, "c": factory})
+	//没有HotRun，Update不会触发热加载，直接调用reloadByAction
+	defer mgr.destroyAll()
+	cm.Update(map[string]any{"a": 1})
+	if err := mgr.reloadByAction(cm.Actions()); err != nil {
+		t.Fatal(err)
+	}
+	//循环依赖，热加载失败，不能记录为已加载
+	deps["b"], deps["c"] = []string{"c"}, []string{"b"}
+	cm.Update(map[string]any{"a": 1, "b": 1, "c": 1})
+	if err := mgr.reloadByAction(cm.Actions()); err == nil {
+		t.Fatal("expect reload error")
+	}
+	if loaded := cm.Loaded(); len(loaded) != 1 || loaded["a"] == nil {
+		t.Fatalf("failed reload should not be committed, got %v", loaded)
+	}
+	//配置修正之后重新加载
+	deps["c"] = nil
+	if err := mgr.reloadByAction(cm.Actions()); err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.Loaded()) != 3 || mgr.GetModuleByName("b") == nil || mgr.GetModuleByName("c") == nil {
+		t.Fatalf("modules not loaded after fix, got %v", cm.Loaded())
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"github.com/YiuTerran/go-common/module"
+	"strings"
+)
+
+/**  按模块依赖计算启动顺序
+**/
+
+func dependsOn(mi module.Module) []string {
+	if d, ok := mi.(module.Dependent); ok {
+		return d.DependsOn()
+	}
+	return nil
+}
+
+// sortModules 拓扑排序，被依赖的模块排在前面；没有依赖关系的模块保持传入的顺序
+// 不在mis中的依赖会被忽略，由调用方自行检查
+func sortModules(mis []module.Module) ([]module.Module, error) {
+	index := make(map[string]int, len(mis))
+	for i, mi := range mis {
+		if _, ok := index[mi.Name()]; ok {
+			return nil, fmt.Errorf("duplicate module %s", mi.Name())
+		}
+		index[mi.Name()] = i
+	}
+	inDegree := make([]int, len(mis))
+	dependents := make([][]int, len(mis))
+	for i, mi := range mis {
+		for _, dep := range dependsOn(mi) {
+			j, ok := index[dep]
+			if !ok {
+				continue
+			}
+			inDegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	resp := make([]module.Module, 0, len(mis))
+	done := make([]bool, len(mis))
+	//每次都从头找第一个入度为0的模块，保证顺序稳定；模块数量不多，O(n^2)无所谓
+	for len(resp) < len(mis) {
+		next := -1
+		for i := range mis {
+			if !done[i] && inDegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("circular module dependency: %s", findCycle(mis, index, done))
+		}
+		done[next] = true
+		resp = append(resp, mis[next])
+		for _, i := range dependents[next] {
+			inDegree[i]--
+		}
+	}
+	return resp, nil
+}
+
+// findCycle 在剩下的模块中找出一个环，用于错误提示
+func findCycle(mis []module.Module, index map[string]int, done []bool) string {
+	visited := make(map[int]int) //下标 -> 在路径中的位置
+	var path []string
+	cur := -1
+	for i := range mis {
+		if !done[i] {
+			cur = i
+			break
+		}
+	}
+	for cur >= 0 {
+		if pos, ok := visited[cur]; ok {
+			return strings.Join(append(path[pos:], mis[cur].Name()), " -> ")
+		}
+		visited[cur] = len(path)
+		path = append(path, mis[cur].Name())
+		next := -1
+		for _, dep := range dependsOn(mis[cur]) {
+			if j, ok := index[dep]; ok && !done[j] {
+				next = j
+				break
+			}
+		}
+		cur = next
+	}
+	return strings.Join(path, " -> ")
+}
+
+// missingDependencies 返回mis依赖但不在exists中的模块
+func missingDependencies(mis []module.Module, exists func(name string) bool) []string {
+	var missing []string
+	for _, mi := range mis {
+		for _, dep := range dependsOn(mi) {
+			if !exists(dep) {
+				missing = append(missing, fmt.Sprintf("%s(required by %s)", dep, mi.Name()))
+			}
+		}
+	}
+	return missing
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"github.com/YiuTerran/go-common/base/structs/rpc"
+	"github.com/YiuTerran/go-common/module"
+	"strings"
+	"testing"
+)
+
+type depMod struct {
+	name string
+	deps []string
+}
+
+func (m *depMod) Name() string            { return m.name }
+func (m *depMod) OnInit()                 {}
+func (m *depMod) Tags() []string          { return nil }
+func (m *depMod) OnDestroy()              {}
+func (m *depMod) Run(ctx context.Context) { <-ctx.Done() }
+func (m *depMod) RPC() rpc.IServer        { return nil }
+func (m *depMod) DependsOn() []string     { return m.deps }
+
+func names(mis []module.Module) string {
+	var resp []string
+	for _, mi := range mis {
+		resp = append(resp, mi.Name())
+	}
+	return strings.Join(resp, ",")
+}
+
+func TestSortModules(t *testing.T) {
+	mis, err := sortModules([]module.Module{
+		&depMod{name: "http", deps: []string{"db", "cache"}},
+		&depMod{name: "cache", deps: []string{"db"}},
+		&depMod{name: "db"},
+		&depMod{name: "log"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := names(mis); r != "db,cache,http,log" {
+		t.Fatalf("unexpected order %s", r)
+	}
+	_, err = sortModules([]module.Module{
+		&depMod{name: "a", deps: []string{"b"}},
+		&depMod{name: "b", deps: []string{"c"}},
+		&depMod{name: "c", deps: []string{"a"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Fatalf("expect cycle error, got %v", err)
+	}
+}
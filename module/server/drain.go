@@ -0,0 +1,108 @@
+package server
+
+import (
+	"github.com/YiuTerran/go-common/base/log"
+	"github.com/YiuTerran/go-common/module"
+	"time"
+)
+
+/**  关闭模块时的优雅退出
+**/
+
+const drainCheckInterval = 50 * time.Millisecond
+
+const defaultDrainTimeout = 30 * time.Second
+
+// stopGracePeriod drain结束(或者超时)并取消ctx之后，等待主循环退出的时间
+const stopGracePeriod = 5 * time.Second
+
+// SetDrainTimeout 设置关闭单个模块时最多等待的时间，默认30秒，<=0表示一直等待
+// 模块可以实现module.DrainTimeouter单独设置
+func (mgr *Manager) SetDrainTimeout(d time.Duration) {
+	mgr.drainTimeout = d
+}
+
+// SetDrainTimeout 设置关闭单个模块时最多等待的时间，默认30秒，<=0表示一直等待
+func SetDrainTimeout(d time.Duration) {
+	defaultManager.SetDrainTimeout(d)
+}
+
+// SetShutdownTimeout 设置关闭服务时所有模块总共最多等待的时间，默认不限制，<=0表示不限制
+// 每个模块的drain超时时间不会超过剩余的时间，一般设置成比k8s的terminationGracePeriodSeconds小一些
+func (mgr *Manager) SetShutdownTimeout(d time.Duration) {
+	mgr.shutdownTimeout = d
+}
+
+// SetShutdownTimeout 设置关闭服务时所有模块总共最多等待的时间，默认不限制，<=0表示不限制
+func SetShutdownTimeout(d time.Duration) {
+	defaultManager.SetShutdownTimeout(d)
+}
+
+// shutdownDeadline 关闭所有模块的截止时间，零值表示没有限制
+func (mgr *Manager) shutdownDeadline() time.Time {
+	if mgr.shutdownTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(mgr.shutdownTimeout)
+}
+
+// drainDeadline 模块关闭的截止时间，不超过limit，零值表示没有限制
+func (mgr *Manager) drainDeadline(mi module.Module, limit time.Time) time.Time {
+	d := mgr.drainTimeout
+	if dt, ok := mi.(module.DrainTimeouter); ok {
+		d = dt.DrainTimeout()
+	}
+	if d <= 0 {
+		return limit
+	}
+	return earlier(time.Now().Add(d), limit)
+}
+
+// earlier 返回较早的截止时间，零值表示没有限制
+func earlier(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// drain 停止接收新的请求，等待已经接收的请求处理完毕
+func (m *mod) drain(deadline time.Time) {
+	d, ok := m.mi.(module.Drainer)
+	if !ok {
+		return
+	}
+	d.Drain()
+	//主循环已经退出了，没有人处理队列
+	if state, _ := m.getState(); state != StateRunning {
+		return
+	}
+	for !d.Drained() {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			log.Warn("module %s drain timeout, pending requests dropped", m.mi.Name())
+			return
+		}
+		time.Sleep(drainCheckInterval)
+	}
+}
+
+// waitUntil 在deadline之前等待f返回，超时返回false
+func waitUntil(deadline time.Time, f func()) bool {
+	if deadline.IsZero() {
+		f()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/YiuTerran/go-common/module"
+	"net/http"
+	"sort"
+	"time"
+)
+
+/**  模块健康检查，可以直接用于k8s的探针
+**/
+
+// State 模块的运行状态
+type State int
+
+const (
+	StateInitializing State = iota
+	StateRunning
+	StateStopped
+	StateCrashed
+	// StateSuspended 通过管理接口手动停止，不影响liveness
+	StateSuspended
+)
+
+func (s State) String() string {
+	switch s {
+	case StateInitializing:
+		return "initializing"
+	case StateRunning:
+		return "running"
+	case StateStopped:
+		return "stopped"
+	case StateCrashed:
+		return "crashed"
+	case StateSuspended:
+		return "suspended"
+	}
+	return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	for _, st := range []State{StateInitializing, StateRunning, StateStopped, StateCrashed, StateSuspended} {
+		if st.String() == string(text) {
+			*s = st
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown module state %s", text)
+}
+
+// ModuleHealth 单个模块的健康状况
+type ModuleHealth struct {
+	Name  string `json:"name"`
+	State State  `json:"state"`
+	Error string `json:"error,omitempty"`
+	// Restarting 崩溃或者退出之后正在等待重启
+	Restarting bool `json:"restarting,omitempty"`
+}
+
+// HealthReport 所有模块汇总之后的健康状况
+type HealthReport struct {
+	Ok      bool           `json:"ok"`
+	Error   string         `json:"error,omitempty"`
+	Modules []ModuleHealth `json:"modules"`
+}
+
+func (mgr *Manager) setStarted(v bool) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.started = v
+}
+
+func (mgr *Manager) setClosing() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.closing = true
+}
+
+func (mgr *Manager) checkHealth(readiness bool) *HealthReport {
+	//复制一份模块列表，探测时不持有锁，避免Alive/Ready中调用GetModuleByName之类的方法时死锁或者阻塞其他调用
+	mgr.lock.RLock()
+	started, closing := mgr.started, mgr.closing
+	mods := make(map[string]*mod, len(mgr.mods))
+	for name, m := range mgr.mods {
+		mods[name] = m
+	}
+	mgr.lock.RUnlock()
+
+	report := &HealthReport{Ok: true, Modules: make([]ModuleHealth, 0, len(mods))}
+	if readiness && !started {
+		report.Ok, report.Error = false, "server starting"
+	}
+	if readiness && closing {
+		report.Ok, report.Error = false, "server closing"
+	}
+	for name, m := range mods {
+		state, reason, restarting := m.getHealthState()
+		mh := ModuleHealth{Name: name, State: state, Error: reason}
+		var err error
+		if hc, ok := m.mi.(module.HealthChecker); ok {
+			if readiness {
+				err = hc.Ready()
+			} else {
+				err = hc.Alive()
+			}
+		}
+		healthy := true
+		switch {
+		case err != nil:
+			mh.Error, healthy = err.Error(), false
+		case state == StateCrashed || state == StateStopped:
+			//已经销毁的模块不在mods中，所以这里都是意外退出的
+			if mh.Error == "" {
+				mh.Error = state.String()
+			}
+			//等待重启的模块只是暂时不能提供服务，重启次数用完之后才算不存活
+			mh.Restarting = restarting
+			healthy = !readiness && restarting
+		case readiness && state != StateRunning:
+			mh.Error, healthy = "not running", false
+		}
+		if !healthy {
+			report.Ok = false
+		}
+		report.Modules = append(report.Modules, mh)
+	}
+	sort.Slice(report.Modules, func(i, j int) bool {
+		return report.Modules[i].Name < report.Modules[j].Name
+	})
+	return report
+}
+
+// Liveness 进程是否存活，有模块崩溃或者意外退出并且不会再重启、Alive检查失败时返回false
+// 等待重启的模块不影响liveness，只影响readiness
+func (mgr *Manager) Liveness() *HealthReport {
+	return mgr.checkHealth(false)
+}
+
+// Readiness 服务是否可以对外提供服务
+// 启动完成之前、关闭过程中、有模块不在运行(包括等待重启)或者Ready检查失败时返回false
+func (mgr *Manager) Readiness() *HealthReport {
+	return mgr.checkHealth(true)
+}
+
+// WaitReady 等待服务就绪，一般在AfterInitModule中调用，就绪之后再注册到注册中心
+func (mgr *Manager) WaitReady(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		report := mgr.Readiness()
+		if report.Ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			bs, _ := json.Marshal(report)
+			return errors.New("server not ready: " + string(bs))
+		case <-ticker.C:
+		}
+	}
+}
+
+func healthHandler(check func() *HealthReport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := check()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if report.Ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// LivenessHandler liveness探针，正常时返回200，否则返回503
+func (mgr *Manager) LivenessHandler() http.Handler {
+	return healthHandler(mgr.Liveness)
+}
+
+// ReadinessHandler readiness探针，正常时返回200，否则返回503
+func (mgr *Manager) ReadinessHandler() http.Handler {
+	return healthHandler(mgr.Readiness)
+}
+
+// Liveness 进程是否存活，有模块崩溃或者意外退出并且不会再重启、Alive检查失败时返回false
+// 等待重启的模块不影响liveness，只影响readiness
+func Liveness() *HealthReport {
+	return defaultManager.Liveness()
+}
+
+// Readiness 服务是否可以对外提供服务
+func Readiness() *HealthReport {
+	return defaultManager.Readiness()
+}
+
+// WaitReady 等待服务就绪，一般在AfterInitModule中调用，就绪之后再注册到注册中心
+func WaitReady(ctx context.Context) error {
+	return defaultManager.WaitReady(ctx)
+}
+
+// LivenessHandler liveness探针，正常时返回200，否则返回503
+func LivenessHandler() http.Handler {
+	return defaultManager.LivenessHandler()
+}
+
+// ReadinessHandler readiness探针，正常时返回200，否则返回503
+func ReadinessHandler() http.Handler {
+	return defaultManager.ReadinessHandler()
+}
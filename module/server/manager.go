@@ -8,7 +8,11 @@ import (
 	"github.com/YiuTerran/go-common/base/structs/set"
 	"github.com/YiuTerran/go-common/base/structs/wg"
 	"github.com/YiuTerran/go-common/module"
+	"os"
+	"runtime/debug"
+	"runtime/pprof"
 	"sync"
+	"time"
 )
 
 /**
@@ -17,33 +21,120 @@ import (
 **/
 
 type mod struct {
+	mgr      *Manager
 	mi       module.Module
+	ctx      context.Context
 	cancelFn context.CancelFunc
 	wg       *wg.WaitGroup
+
+	stateLock  sync.RWMutex
+	state      State
+	reason     string //崩溃或者退出的原因
+	restarting bool   //正在等待backoff之后重启
+	loadedAt   time.Time
+}
+
+func (mgr *Manager) newMod(mi module.Module) *mod {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &mod{
+		mgr:      mgr,
+		mi:       mi,
+		ctx:      ctx,
+		cancelFn: cancel,
+		wg:       wg.NewWaitGroup(mi.Name()),
+		loadedAt: time.Now(),
+	}
 }
 
-var (
-	ordered    = make([]*mod, 0, 1)
-	mods       = make(map[string]*mod)
-	tags       = make(map[string]*set.Set[string])
+func (m *mod) setState(state State, reason string) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.state = state
+	m.reason = reason
+	m.restarting = false
+}
+
+func (m *mod) getState() (State, string) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.state, m.reason
+}
+
+func (m *mod) setRestarting(v bool) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.restarting = v
+}
+
+// getHealthState 同时返回状态和是否等待重启，避免两次读取之间状态发生变化
+func (m *mod) getHealthState() (State, string, bool) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.state, m.reason, m.restarting
+}
+
+// Manager 管理一组模块的生命周期
+// 一般直接使用包级别的函数(默认Manager)即可，需要在一个进程里运行多组模块(比如测试)时才需要自己创建
+type Manager struct {
+	ordered    []*mod
+	mods       map[string]*mod
+	tags       map[string]*set.Set[string]
 	lock       sync.RWMutex
+	//串行化模块的加载和卸载，drain等耗时操作只持有opLock，不阻塞GetModuleByName之类的查询
+	opLock     sync.Mutex
 	staticMode bool //静态模式
-)
+	started    bool //所有模块都已经初始化
+	closing    bool //正在关闭
+
+	closeChn  chan os.Signal
+	reloadChn chan int
+
+	beforeCloseModuleCb func()
+	afterInitModuleCb   func()
+
+	defaultRestartPolicy module.RestartPolicy
+	moduleRestartCb      func(RestartEvent)
+	defaultPanicPolicy   module.PanicPolicy
+	moduleCrashCb        func(module.CrashReport)
+	drainTimeout         time.Duration
+	shutdownTimeout      time.Duration //关闭服务时所有模块总共的时间预算
+
+	getMods    GetModuleActions //HotRun时的模块来源，用于重载指定模块
+	lastReload time.Time
+
+	remotes map[string]module.Module //远程模块，本地没有同名模块时才使用
+
+	reloadedCbs []func(map[Action][]module.Module) //热加载成功之后的回调
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		ordered:      make([]*mod, 0, 1),
+		mods:         make(map[string]*mod),
+		tags:         make(map[string]*set.Set[string]),
+		remotes:      make(map[string]module.Module),
+		closeChn:     make(chan os.Signal, 1),
+		reloadChn:    make(chan int, 1),
+		drainTimeout: defaultDrainTimeout,
+	}
+}
 
 // GetModuleByName 通过名称查找mod，类似spring查找bean
-func GetModuleByName(name string) module.Module {
-	return GetModuleByNameFunc(name)()
+func (mgr *Manager) GetModuleByName(name string) module.Module {
+	return mgr.GetModuleByNameFunc(name)()
 }
 
 // ForEachModule 有tag的每个模块，异步执行函数
-func ForEachModule(tag []string, id any, args ...any) {
+func (mgr *Manager) ForEachModule(tag []string, id any, args ...any) {
 	var ts []module.Module
 	if len(tag) > 0 {
-		ts = GetModuleByTag(tag...)
+		ts = mgr.GetModuleByTag(tag...)
 	} else {
-		for _, m := range mods {
+		mgr.lock.RLock()
+		for _, m := range mgr.mods {
 			ts = append(ts, m.mi)
 		}
+		mgr.lock.RUnlock()
 	}
 	for _, t := range ts {
 		t.RPC().Go(id, args...)
@@ -51,14 +142,15 @@ func ForEachModule(tag []string, id any, args ...any) {
 }
 
 // GetModuleByNameFunc 热加载时module是会变的，所以返回一个函数
+// 本地没有该模块时返回RegisterRemote注册的远程模块
 // 高阶函数
-func GetModuleByNameFunc(name string) func() module.Module {
+func (mgr *Manager) GetModuleByNameFunc(name string) func() module.Module {
 	return func() module.Module {
-		lock.RLock()
-		defer lock.RUnlock()
-		m, ok := mods[name]
+		mgr.lock.RLock()
+		defer mgr.lock.RUnlock()
+		m, ok := mgr.mods[name]
 		if !ok {
-			return nil
+			return mgr.remotes[name]
 		}
 		return m.mi
 	}
@@ -66,19 +158,19 @@ func GetModuleByNameFunc(name string) func() module.Module {
 
 // GetModuleByTag 通过Tag查找模块
 // 可以传入多个tag，取交集
-func GetModuleByTag(tag ...string) []module.Module {
-	return GetModuleByTagFunc(tag...)()
+func (mgr *Manager) GetModuleByTag(tag ...string) []module.Module {
+	return mgr.GetModuleByTagFunc(tag...)()
 }
 
 // GetModuleByTagFunc 获取一个函数，执行可以动态获取tag对应的module
 // 适用于Module会动态热加载的场景
-func GetModuleByTagFunc(tag ...string) func() []module.Module {
+func (mgr *Manager) GetModuleByTagFunc(tag ...string) func() []module.Module {
 	return func() []module.Module {
-		lock.RLock()
-		defer lock.RUnlock()
+		mgr.lock.RLock()
+		defer mgr.lock.RUnlock()
 		s := set.NewSet[string]()
 		for i, t := range tag {
-			m, ok := tags[t]
+			m, ok := mgr.tags[t]
 			if !ok {
 				return nil
 			}
@@ -94,122 +186,268 @@ func GetModuleByTagFunc(tag ...string) func() []module.Module {
 		}
 		resp := make([]module.Module, 0, s.Size())
 		s.ForEach(func(k string) {
-			resp = append(resp, GetModuleByName(k))
+			if m, ok := mgr.mods[k]; ok {
+				resp = append(resp, m.mi)
+			}
 		})
 		return resp
 	}
 }
 
-func reloadByAction(actionMds map[Action][]module.Module) {
-	lock.Lock()
-	defer lock.Unlock()
-	if staticMode {
-		return
+func (mgr *Manager) reloadByAction(actionMds map[Action][]module.Module) error {
+	mgr.opLock.Lock()
+	defer mgr.opLock.Unlock()
+	if mgr.isStatic() {
+		return nil
 	}
-	for action, mis := range actionMds {
-		//不管是哪种行为，都要删除旧模块
-		for _, mi := range mis {
-			if old, ok := mods[mi.Name()]; !ok {
+	var (
+		olds    []module.Module
+		inits   []module.Module
+		oldMods = make(map[string]*mod)
+	)
+	mgr.lock.RLock()
+	//不管是哪种行为，都要删除旧模块
+	for _, action := range []Action{Delete, Update, New} {
+		for _, mi := range actionMds[action] {
+			if old, ok := mgr.mods[mi.Name()]; !ok {
 				if action != New {
 					log.Info("no active module %s, ignore", mi.Name())
 				}
 			} else {
-				destroyMod(old)
+				olds = append(olds, old.mi)
+				oldMods[mi.Name()] = old
 				if action == New {
 					log.Warn("register new module but old exists, destroy module %s", mi.Name())
 				}
 			}
-		}
-		//新增模块
-		if action == New || action == Update {
-			for _, mi := range mis {
-				m := &mod{
-					wg: wg.NewWaitGroup(mi.Name()),
-				}
-				m.mi = mi
-				mods[mi.Name()] = m
-				for _, tag := range mi.Tags() {
-					s, ok := tags[tag]
-					if !ok {
-						tags[tag] = set.NewSet[string](mi.Name())
-					} else {
-						s.AddItem(mi.Name())
-					}
-				}
-				mi.OnInit()
-				m.wg.Add(1)
-				go run(m)
-				log.Info("module registered: %s", mi.Name())
+			if action == New || action == Update {
+				inits = append(inits, mi)
 			}
 		}
 	}
+	mgr.lock.RUnlock()
+	inits, err := sortModules(inits)
+	if err != nil {
+		return err
+	}
+	olds, err = sortModules(lo.UniqBy(olds, module.Module.Name))
+	if err != nil {
+		return err
+	}
+	//按依赖的逆序销毁旧模块
+	for i := len(olds) - 1; i >= 0; i-- {
+		mgr.destroyMod(oldMods[olds[i].Name()], time.Time{})
+	}
+	mgr.lock.Lock()
+	if missing := missingDependencies(inits, func(name string) bool {
+		_, ok := mgr.mods[name]
+		return ok || lo.ContainsBy(inits, func(mi module.Module) bool { return mi.Name() == name })
+	}); len(missing) > 0 {
+		log.Warn("modules not loaded: %v", missing)
+	}
+	//新增模块，被依赖的先初始化
+	for _, mi := range inits {
+		mgr.initMod(mi)
+	}
+	mgr.lastReload = time.Now()
+	err = mgr.sortOrdered()
+	cbs := mgr.reloadedCbs
+	mgr.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, cb := range cbs {
+		cb(actionMds)
+	}
+	return nil
 }
 
-// staticLoadModules 静态加载，按严格的顺序加载模块
-func staticLoadModules(mis []module.Module) {
-	lock.Lock()
-	defer lock.Unlock()
-	staticMode = true
-	for _, mi := range mis {
-		m := &mod{
-			wg: wg.NewWaitGroup(mi.Name()),
+// onReloaded 热加载成功之后调用cb，参数是本次应用的模块变化
+func (mgr *Manager) onReloaded(cb func(map[Action][]module.Module)) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.reloadedCbs = append(mgr.reloadedCbs, cb)
+}
+
+// sortOrdered 热加载之后重新计算所有模块的顺序，用于最后的销毁
+func (mgr *Manager) sortOrdered() error {
+	mis := make([]module.Module, 0, len(mgr.mods))
+	names := set.NewSet[string]()
+	for _, m := range mgr.ordered {
+		if mgr.mods[m.mi.Name()] == m {
+			mis = append(mis, m.mi)
+			names.AddItem(m.mi.Name())
 		}
-		m.mi = mi
-		mods[mi.Name()] = m
-		for _, t := range mi.Tags() {
-			if s := tags[t]; s == nil {
-				tags[t] = set.NewSet[string](mi.Name())
-			} else {
-				s.AddItem(mi.Name())
-			}
+	}
+	for name, m := range mgr.mods {
+		if !names.Contains(name) {
+			mis = append(mis, m.mi)
+		}
+	}
+	mis, err := sortModules(mis)
+	if err != nil {
+		return err
+	}
+	mgr.ordered = lo.Map(mis, func(mi module.Module, _ int) *mod {
+		return mgr.mods[mi.Name()]
+	})
+	return nil
+}
+
+func (mgr *Manager) initMod(mi module.Module) {
+	m := mgr.newMod(mi)
+	mgr.mods[mi.Name()] = m
+	for _, t := range mi.Tags() {
+		if s := mgr.tags[t]; s == nil {
+			mgr.tags[t] = set.NewSet[string](mi.Name())
+		} else {
+			s.AddItem(mi.Name())
 		}
+	}
+	mi.OnInit()
+	m.wg.Add(1)
+	go m.run()
+	log.Info("module registered: %s", mi.Name())
+}
+
+// staticLoadModules 静态加载，按依赖关系和传入的顺序加载模块
+func (mgr *Manager) staticLoadModules(mis []module.Module) error {
+	mgr.opLock.Lock()
+	defer mgr.opLock.Unlock()
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mis, err := sortModules(mis)
+	if err != nil {
+		return err
+	}
+	if missing := missingDependencies(mis, func(name string) bool {
+		return lo.ContainsBy(mis, func(mi module.Module) bool { return mi.Name() == name })
+	}); len(missing) > 0 {
+		return fmt.Errorf("modules not loaded: %v", missing)
+	}
+	for _, mi := range mis {
+		mgr.initMod(mi)
 		//记下启动顺序
-		ordered = append(ordered, m)
-		mi.OnInit()
-		m.wg.Add(1)
-		go run(m)
-		log.Info("module registered: %s", mi.Name())
+		mgr.ordered = append(mgr.ordered, mgr.mods[mi.Name()])
 	}
 	log.Info(">>>>>>>>>>>>>>>>Server Started<<<<<<<<<<<<<<<<<<<<<<<")
+	return nil
+}
+
+// destroyMod 停止模块并从Manager中移除，调用方需要持有opLock，不能持有lock
+// 停止的过程中模块仍然可以被查询到，处理剩余请求时可以调用其他模块
+// limit是最晚的截止时间，零值表示没有限制
+func (mgr *Manager) destroyMod(m *mod, limit time.Time) {
+	mgr.stopMod(m, limit)
+	mgr.lock.Lock()
+	if mgr.mods[m.mi.Name()] == m {
+		delete(mgr.mods, m.mi.Name())
+		for _, tag := range m.mi.Tags() {
+			if mgr.tags[tag] != nil {
+				mgr.tags[tag].RemoveItem(m.mi.Name())
+			}
+		}
+	}
+	mgr.lock.Unlock()
+	log.Info("mod destroyed: %s", m.mi.Name())
 }
 
-func destroyMod(m *mod) {
+// stopMod 停止模块的主循环并调用OnDestroy，不从Manager中移除
+// limit是最晚的截止时间，零值表示没有限制
+func (mgr *Manager) stopMod(m *mod, limit time.Time) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.PanicStack(fmt.Sprintf("panic when destory module %s", m.mi.Name()), r)
 		}
 	}()
+	deadline := mgr.drainDeadline(m.mi, limit)
+	m.drain(deadline)
 	m.cancelFn()
-	m.wg.Wait()
+	//drain超时的时候deadline已经过了，主循环退出需要单独等待一段时间
+	if !deadline.IsZero() {
+		deadline = earlier(time.Now().Add(stopGracePeriod), limit)
+	}
+	if !waitUntil(deadline, m.wg.Wait) {
+		log.Error("module %s still running after drain timeout, skip waiting", m.mi.Name())
+	}
 	m.mi.OnDestroy()
-	delete(mods, m.mi.Name())
-	for _, tag := range m.mi.Tags() {
-		if tags[tag] != nil {
-			tags[tag].RemoveItem(m.mi.Name())
-		}
+}
+
+func (mgr *Manager) destroyAll() {
+	mgr.opLock.Lock()
+	defer mgr.opLock.Unlock()
+	mgr.lock.Lock()
+	ordered := append([]*mod(nil), mgr.ordered...)
+	mgr.ordered = mgr.ordered[:0]
+	mgr.lock.Unlock()
+	//按着启动顺序的逆序销毁模块，所有模块共用关闭的时间预算
+	limit := mgr.shutdownDeadline()
+	for i := len(ordered) - 1; i >= 0; i-- {
+		log.Debug("destroying module %s", ordered[i].mi.Name())
+		mgr.destroyMod(ordered[i], limit)
+	}
+	if !limit.IsZero() && time.Now().After(limit) {
+		log.Warn("shutdown timeout exceeded, some modules may not be drained")
 	}
-	log.Info("mod destroyed: %s", m.mi.Name())
 }
 
-func destroyAll() {
-	lock.Lock()
-	defer lock.Unlock()
-	//静态模式下按着严格的逆序销毁模块
-	if staticMode {
-		for i := len(ordered) - 1; i >= 0; i-- {
-			destroyMod(ordered[i])
+func (m *mod) run() {
+	defer m.wg.Done()
+	//模块协程及其创建的协程都带上模块名的标签，用于按模块导出协程栈
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(moduleLabel, m.mi.Name())))
+	var restarts int
+	for {
+		startAt := time.Now()
+		crashed, policy := m.runOnce()
+		//稳定运行一段时间之后重新计数
+		if m.mgr.restartPolicyOf(m.mi).Stable(time.Since(startAt)) {
+			restarts = 0
+		}
+		for {
+			restarts++
+			if m.ctx.Err() != nil || !m.supervise(crashed, policy, restarts) {
+				return
+			}
+			if m.restart() {
+				break
+			}
+			crashed = true
 		}
-		return
-	}
-	for _, mod := range mods {
-		log.Debug("destroying module %s", mod.mi.Name())
-		destroyMod(mod)
 	}
 }
 
-func run(m *mod) {
-	ctx, cancel := context.WithCancel(context.Background())
-	m.cancelFn = cancel
-	m.mi.Run(ctx)
-	m.wg.Done()
+// runOnce 执行一次模块的主循环，返回是否是panic退出的，以及消息处理panic时模块的PanicPolicy
+func (m *mod) runOnce() (crashed bool, policy module.PanicPolicy) {
+	defer func() {
+		if r := recover(); r != nil {
+			crashed = true
+			if report, ok := r.(*module.CrashReport); ok {
+				//消息处理时的panic已经记录过日志和崩溃报告了
+				policy = report.Policy
+				m.setState(StateCrashed, report.Error())
+				return
+			}
+			stack := string(debug.Stack())
+			log.Error("module %s crashed: %v-> %s", m.mi.Name(), r, stack)
+			m.setState(StateCrashed, fmt.Sprintf("panic: %v", r))
+			m.mgr.fireCrashReport(module.CrashReport{
+				Module:  m.mi.Name(),
+				Message: fmt.Sprint(r),
+				Stack:   stack,
+				Time:    time.Now(),
+			})
+		} else if m.ctx.Err() == nil {
+			log.Error("module %s exit unexpectedly", m.mi.Name())
+			m.setState(StateStopped, "exit unexpectedly")
+		} else {
+			m.setState(StateStopped, "")
+		}
+	}()
+	m.setState(StateRunning, "")
+	//模块可能在OnInit中才创建GoroutineMixIn，每次运行前都要重新设置
+	if g, ok := m.mi.(module.PanicGuard); ok {
+		g.SetPanicPolicy(m.mi.Name(), m.mgr.panicPolicyOf(m.mi), m.mgr.fireCrashReport)
+	}
+	m.mi.Run(m.ctx)
+	return
 }
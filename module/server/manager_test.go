@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"github.com/YiuTerran/go-common/module"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManager_RunAndClose(t *testing.T) {
+	m1, m2 := NewManager(), NewManager()
+	done := make(chan struct{}, 2)
+	go func() {
+		m1.Run(&depMod{name: "a"}, &depMod{name: "b", deps: []string{"a"}})
+		done <- struct{}{}
+	}()
+	go func() {
+		m2.HotRun(func() map[Action][]module.Module {
+			return map[Action][]module.Module{New: {&depMod{name: "a"}}}
+		})
+		done <- struct{}{}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for _, mgr := range []*Manager{m1, m2} {
+		if err := mgr.WaitReady(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//两个Manager互不影响
+	if m1.GetModuleByName("b") == nil || m2.GetModuleByName("b") != nil {
+		t.Fatal("modules should be isolated between managers")
+	}
+	m1.Close()
+	<-done
+	if m1.GetModuleByName("a") != nil || m2.GetModuleByName("a") == nil {
+		t.Fatal("close one manager should not affect the other")
+	}
+	m2.Close()
+	<-done
+	if report := m2.Liveness(); !report.Ok || len(report.Modules) != 0 {
+		t.Fatal("all modules should be destroyed")
+	}
+}
+
+func TestManager_RegisterRemote(t *testing.T) {
+	mgr := NewManager()
+	remote := module.NewGoroutineMixIn()
+	remote.Register("add", func(args []any) any { return args[0].(int) + args[1].(int) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go remote.Run(ctx)
+	mgr.RegisterRemote("a", remote.RPC())
+	m := mgr.GetModuleByName("a")
+	if m == nil || m.RPC() != remote.RPC() {
+		t.Fatal("remote module not found")
+	}
+	//调用方式和本地模块相同
+	if ret, err := m.RPC().Call1("add", 1, 2); err != nil || ret != 3 {
+		t.Fatalf("expect 3, got %v, %v", ret, err)
+	}
+	//本地模块优先
+	local := &depMod{name: "a"}
+	if err := mgr.staticLoadModules([]module.Module{local}); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.GetModuleByName("a") != local {
+		t.Fatal("local module should take precedence")
+	}
+	mgr.destroyAll()
+	mgr.UnregisterRemote("a")
+	if mgr.GetModuleByName("a") != nil {
+		t.Fatal("remote module should be unregistered")
+	}
+}
+
+type crashMod struct {
+	*module.GoroutineMixIn
+	name   string
+	policy module.PanicPolicy
+	inits  int32
+}
+
+func (m *crashMod) Name() string                    { return m.name }
+func (m *crashMod) OnInit()                         { atomic.AddInt32(&m.inits, 1) }
+func (m *crashMod) PanicPolicy() module.PanicPolicy { return m.policy }
+
+func newCrashMod(name string, policy module.PanicPolicy) *crashMod {
+	m := &crashMod{GoroutineMixIn: module.NewGoroutineMixIn(), name: name, policy: policy}
+	m.Register("boom", func([]any) { panic("boom") })
+	return m
+}
+
+// lazyMod 在OnInit中才创建GoroutineMixIn
+type lazyMod struct {
+	*module.GoroutineMixIn
+}
+
+func (m *lazyMod) Name() string { return "lazy" }
+func (m *lazyMod) OnInit()      { m.GoroutineMixIn = module.NewGoroutineMixIn() }
+
+func TestManager_PanicPolicy(t *testing.T) {
+	mgr := NewManager()
+	reports := make(chan module.CrashReport, 2)
+	mgr.OnModuleCrash(func(r module.CrashReport) {
+		reports <- r
+	})
+	restart, exit := newCrashMod("restart", module.PanicRestart), newCrashMod("exit", module.PanicExit)
+	done := make(chan struct{})
+	go func() {
+		mgr.Run(restart, exit, &lazyMod{})
+		close(done)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := restart.Call0("boom"); err == nil {
+		t.Fatal("expect error")
+	}
+	if r := <-reports; r.Module != "restart" || r.Id != "boom" || r.Policy != module.PanicRestart {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	//默认的RestartPolicy是不重启，PanicRestart仍然会重启模块
+	for atomic.LoadInt32(&restart.inits) != 2 || !mgr.Readiness().Ok {
+		if ctx.Err() != nil {
+			t.Fatal("module not restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	exit.RpcServer.Go("boom")
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("server should exit")
+	}
+	if r := <-reports; r.Module != "exit" {
+		t.Fatalf("unexpected report %+v", r)
+	}
+}
+
+// lookupMod 处理请求时查询其他模块
+type lookupMod struct {
+	*module.GoroutineMixIn
+	mgr *Manager
+}
+
+func (m *lookupMod) Name() string        { return "lookup" }
+func (m *lookupMod) OnInit()             {}
+func (m *lookupMod) DependsOn() []string { return []string{"a"} }
+
+func TestManager_LookupWhileDraining(t *testing.T) {
+	mgr := NewManager()
+	mgr.SetDrainTimeout(2 * time.Second)
+	m := &lookupMod{GoroutineMixIn: module.NewGoroutineMixIn(), mgr: mgr}
+	found := make(chan bool, 1)
+	m.Register("lookup", func([]any) {
+		time.Sleep(100 * time.Millisecond)
+		found <- mgr.GetModuleByName("a") != nil
+	})
+	done := make(chan struct{})
+	go func() {
+		mgr.Run(&depMod{name: "a"}, m)
+		close(done)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.RpcServer.Go("lookup")
+	start := time.Now()
+	mgr.Close()
+	<-done
+	//关闭时不持有锁，剩余的请求可以正常查询模块，不需要等到drain超时
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close took %v", elapsed)
+	}
+	if !<-found {
+		t.Fatal("dependency should be available while draining")
+	}
+}
+
+func TestManager_RestartStable(t *testing.T) {
+	mgr := NewManager()
+	mgr.SetDefaultRestartPolicy(module.RestartPolicy{Mode: module.RestartOnFailure, MaxRestarts: 1,
+		StableAfter: 100 * time.Millisecond})
+	events := make(chan RestartEvent, 4)
+	mgr.OnModuleRestart(func(e RestartEvent) {
+		events <- e
+	})
+	m := newCrashMod("crash", module.PanicRestart)
+	go mgr.Run(m)
+	defer mgr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	crash := func() RestartEvent {
+		if err := mgr.WaitReady(ctx); err != nil {
+			t.Fatal(err)
+		}
+		_ = m.Call0("boom")
+		select {
+		case e := <-events:
+			return e
+		case <-ctx.Done():
+			t.Fatal("restart event not fired")
+		}
+		return RestartEvent{}
+	}
+	if e := crash(); e.Restarts != 1 || e.Exhausted {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	//稳定运行之后重新计数
+	time.Sleep(150 * time.Millisecond)
+	if e := crash(); e.Restarts != 1 || e.Exhausted {
+		t.Fatalf("restart count should be reset, got %+v", e)
+	}
+	if e := crash(); e.Restarts != 2 || !e.Exhausted {
+		t.Fatalf("expect exhausted, got %+v", e)
+	}
+}
+
+func TestManager_HealthWhileRestarting(t *testing.T) {
+	mgr := NewManager()
+	mgr.SetDefaultRestartPolicy(module.RestartPolicy{Mode: module.RestartOnFailure, MaxRestarts: 1,
+		Backoff: 200 * time.Millisecond})
+	events := make(chan RestartEvent, 2)
+	mgr.OnModuleRestart(func(e RestartEvent) {
+		events <- e
+	})
+	m := newCrashMod("crash", module.PanicRestart)
+	go mgr.Run(m)
+	defer mgr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Call0("boom")
+	<-events
+	//等待重启期间只是暂时不可用
+	if report := mgr.Liveness(); !report.Ok || !report.Modules[0].Restarting {
+		t.Fatalf("liveness should be ok while restarting: %+v", report)
+	}
+	if mgr.Readiness().Ok {
+		t.Fatal("readiness should fail while restarting")
+	}
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//重启次数用完之后不再存活
+	_ = m.Call0("boom")
+	if e := <-events; !e.Exhausted {
+		t.Fatalf("expect exhausted, got %+v", e)
+	}
+	if report := mgr.Liveness(); report.Ok || report.Modules[0].Restarting {
+		t.Fatalf("liveness should fail after restarts exhausted: %+v", report)
+	}
+}
+
+// stuckMod 队列永远处理不完
+type stuckMod struct {
+	*module.GoroutineMixIn
+	name string
+}
+
+func (m *stuckMod) Name() string  { return m.name }
+func (m *stuckMod) OnInit()       {}
+func (m *stuckMod) Drained() bool { return false }
+
+func TestManager_ShutdownTimeout(t *testing.T) {
+	mgr := NewManager()
+	//单个模块的drain超时时间比总的预算长，需要被预算截断
+	mgr.SetShutdownTimeout(300 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		mgr.Run(&stuckMod{GoroutineMixIn: module.NewGoroutineMixIn(), name: "a"},
+			&stuckMod{GoroutineMixIn: module.NewGoroutineMixIn(), name: "b"})
+		close(done)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mgr.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	mgr.Close()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("shutdown timeout not enforced")
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Fatalf("modules should be drained until the budget runs out, took %v", d)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"github.com/YiuTerran/go-common/base/structs/rpc"
+)
+
+/**  远程模块，模块实际运行在其他进程，通过rpc.IServer(比如network/chanrpc.Client)调用
+  *  注册之后GetModuleByName可以直接找到，调用方不需要区分本地和远程
+**/
+
+// remoteModule 只用于查找和调用，不参与生命周期管理
+type remoteModule struct {
+	name   string
+	server rpc.IServer
+}
+
+func (r *remoteModule) Name() string {
+	return r.name
+}
+
+func (r *remoteModule) OnInit() {}
+
+func (r *remoteModule) Tags() []string {
+	return nil
+}
+
+func (r *remoteModule) OnDestroy() {}
+
+func (r *remoteModule) Run(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (r *remoteModule) RPC() rpc.IServer {
+	return r.server
+}
+
+// RegisterRemote 注册远程模块，本地有同名模块时优先使用本地模块
+// 远程模块的连接由调用方管理，Manager不会启动或者关闭它
+func (mgr *Manager) RegisterRemote(name string, server rpc.IServer) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.remotes[name] = &remoteModule{name: name, server: server}
+}
+
+// UnregisterRemote 取消注册远程模块
+func (mgr *Manager) UnregisterRemote(name string) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	delete(mgr.remotes, name)
+}
+
+// RegisterRemote 注册远程模块，本地有同名模块时优先使用本地模块
+func RegisterRemote(name string, server rpc.IServer) {
+	defaultManager.RegisterRemote(name, server)
+}
+
+// UnregisterRemote 取消注册远程模块
+func UnregisterRemote(name string) {
+	defaultManager.UnregisterRemote(name)
+}
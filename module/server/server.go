@@ -5,6 +5,7 @@ import (
 	"github.com/YiuTerran/go-common/module"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 /**  一般server的实现，加载所有Module
@@ -22,86 +23,184 @@ const (
 	Delete
 )
 
-var (
-	serverCloseChn  = make(chan os.Signal, 1)
-	serverReloadChn = make(chan int, 1)
-
+const (
 	quitSig   = 1
 	reloadSig = 2
-
-	beforeCloseModuleCb func()
-	afterInitModuleCb   func()
 )
 
+// defaultManager 包级别函数使用的Manager
+var defaultManager = NewManager()
+
 type GetModuleActions func() map[Action][]module.Module
 
+// Default 返回包级别函数使用的Manager
+func Default() *Manager {
+	return defaultManager
+}
+
 // Close 手动关闭服务
-func Close() {
-	serverCloseChn <- os.Kill
+func (mgr *Manager) Close() {
+	mgr.closeChn <- os.Kill
 }
 
-// Reload 重载所有模块
-func Reload() {
-	serverReloadChn <- reloadSig
+// Reload 重载所有模块，仅在HotRun模式下有效
+func (mgr *Manager) Reload() {
+	if mgr.isStatic() {
+		log.Info("server running in static mode, ignore reload")
+		return
+	}
+	mgr.reloadChn <- reloadSig
+}
+
+func (mgr *Manager) isStatic() bool {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	return mgr.staticMode
+}
+
+// reset 启动前重置状态，同一个Manager关闭之后可以再次启动
+func (mgr *Manager) reset(static bool) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.staticMode = static
+	mgr.started = false
+	mgr.closing = false
 }
 
 // 热加载
-func hotReload(getMods GetModuleActions) {
+func (mgr *Manager) hotReload(getMods GetModuleActions) {
 	for {
-		sig := <-serverReloadChn
+		sig := <-mgr.reloadChn
 		if sig == quitSig {
 			break
 		} else if sig == reloadSig {
-			reloadByAction(getMods())
+			if err := mgr.reloadByAction(getMods()); err != nil {
+				log.Error("reload modules failed: %v", err)
+			}
 		}
 	}
 }
 
 // BeforeCloseModule 服务关闭所有模块之前的hook
 // 一般需要在注册中心先取消注册
-func BeforeCloseModule(cb func()) {
-	beforeCloseModuleCb = cb
+func (mgr *Manager) BeforeCloseModule(cb func()) {
+	mgr.beforeCloseModuleCb = cb
 }
 
 // AfterInitModule 初始化所有module之后的hook
-// 一般需要手动注册到注册中心
-func AfterInitModule(cb func()) {
-	afterInitModuleCb = cb
+// 一般需要手动注册到注册中心，可以先用WaitReady等待服务就绪
+func (mgr *Manager) AfterInitModule(cb func()) {
+	mgr.afterInitModuleCb = cb
 }
 
-func waitClose() {
-	//关闭&&重启
-	signal.Notify(serverCloseChn, os.Interrupt, os.Kill)
-	<-serverCloseChn
+func (mgr *Manager) waitClose() {
+	//关闭&&重启，k8s关闭pod时会发送SIGTERM，SIGHUP用来触发热加载
+	signal.Notify(mgr.closeChn, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := <-mgr.closeChn; sig == syscall.SIGHUP; sig = <-mgr.closeChn {
+		log.Info("receive SIGHUP, reloading...")
+		go mgr.Reload()
+	}
 	log.Info("server closing...")
-	signal.Stop(serverCloseChn)
-	serverReloadChn <- quitSig
-	if beforeCloseModuleCb != nil {
-		beforeCloseModuleCb()
+	mgr.setClosing()
+	signal.Stop(mgr.closeChn)
+	if !mgr.isStatic() {
+		mgr.reloadChn <- quitSig
 	}
-	destroyAll()
+	if mgr.beforeCloseModuleCb != nil {
+		mgr.beforeCloseModuleCb()
+	}
+	mgr.destroyAll()
 	log.Info("all module closed")
 }
 
 // HotRun 开启模块热加载特性
-func HotRun(getMods GetModuleActions) {
+func (mgr *Manager) HotRun(getMods GetModuleActions) {
 	log.Info("server starting up...")
+	mgr.reset(false)
+	mgr.lock.Lock()
+	mgr.getMods = getMods
+	mgr.lock.Unlock()
 	// mod
-	reloadByAction(getMods())
+	if err := mgr.reloadByAction(getMods()); err != nil {
+		panic(err)
+	}
+	mgr.setStarted(true)
 	//注册热加载信号
-	go hotReload(getMods)
-	if afterInitModuleCb != nil {
-		afterInitModuleCb()
+	go mgr.hotReload(getMods)
+	if mgr.afterInitModuleCb != nil {
+		mgr.afterInitModuleCb()
 	}
-	waitClose()
+	mgr.waitClose()
 }
 
 // Run 模块以静态模式加载（关闭热加载特性）
-func Run(mods ...module.Module) {
+func (mgr *Manager) Run(mods ...module.Module) {
 	log.Info("server starting up...")
-	staticLoadModules(mods)
-	if afterInitModuleCb != nil {
-		afterInitModuleCb()
+	mgr.reset(true)
+	if err := mgr.staticLoadModules(mods); err != nil {
+		panic(err)
+	}
+	mgr.setStarted(true)
+	if mgr.afterInitModuleCb != nil {
+		mgr.afterInitModuleCb()
 	}
-	waitClose()
+	mgr.waitClose()
+}
+
+// GetModuleByName 通过名称查找mod，类似spring查找bean
+func GetModuleByName(name string) module.Module {
+	return defaultManager.GetModuleByName(name)
+}
+
+// ForEachModule 有tag的每个模块，异步执行函数
+func ForEachModule(tag []string, id any, args ...any) {
+	defaultManager.ForEachModule(tag, id, args...)
+}
+
+// GetModuleByNameFunc 热加载时module是会变的，所以返回一个函数
+func GetModuleByNameFunc(name string) func() module.Module {
+	return defaultManager.GetModuleByNameFunc(name)
+}
+
+// GetModuleByTag 通过Tag查找模块
+// 可以传入多个tag，取交集
+func GetModuleByTag(tag ...string) []module.Module {
+	return defaultManager.GetModuleByTag(tag...)
+}
+
+// GetModuleByTagFunc 获取一个函数，执行可以动态获取tag对应的module
+func GetModuleByTagFunc(tag ...string) func() []module.Module {
+	return defaultManager.GetModuleByTagFunc(tag...)
+}
+
+// Close 手动关闭服务
+func Close() {
+	defaultManager.Close()
+}
+
+// Reload 重载所有模块，仅在HotRun模式下有效
+func Reload() {
+	defaultManager.Reload()
+}
+
+// BeforeCloseModule 服务关闭所有模块之前的hook
+// 一般需要在注册中心先取消注册
+func BeforeCloseModule(cb func()) {
+	defaultManager.BeforeCloseModule(cb)
+}
+
+// AfterInitModule 初始化所有module之后的hook
+// 一般需要手动注册到注册中心，可以先用WaitReady等待服务就绪
+func AfterInitModule(cb func()) {
+	defaultManager.AfterInitModule(cb)
+}
+
+// HotRun 开启模块热加载特性
+func HotRun(getMods GetModuleActions) {
+	defaultManager.HotRun(getMods)
+}
+
+// Run 模块以静态模式加载（关闭热加载特性）
+func Run(mods ...module.Module) {
+	defaultManager.Run(mods...)
 }
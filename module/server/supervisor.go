@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"github.com/YiuTerran/go-common/module"
+	"time"
+)
+
+/**  模块主循环意外退出之后的重启策略
+**/
+
+// RestartEvent 模块重启事件
+type RestartEvent struct {
+	Name string
+	// Restarts 第几次重启
+	Restarts int
+	// Reason 上次退出的原因
+	Reason string
+	// Backoff 重启前等待的时间
+	Backoff time.Duration
+	// Exhausted 超过最大重启次数，不会再重启
+	Exhausted bool
+}
+
+// SetDefaultRestartPolicy 设置没有实现module.Supervised的模块的重启策略，默认不重启
+func (mgr *Manager) SetDefaultRestartPolicy(policy module.RestartPolicy) {
+	mgr.defaultRestartPolicy = policy
+}
+
+// OnModuleRestart 模块重启(或者超过重启次数放弃重启)时的hook，可以用于告警
+func (mgr *Manager) OnModuleRestart(cb func(RestartEvent)) {
+	mgr.moduleRestartCb = cb
+}
+
+// SetDefaultRestartPolicy 设置没有实现module.Supervised的模块的重启策略，默认不重启
+func SetDefaultRestartPolicy(policy module.RestartPolicy) {
+	defaultManager.SetDefaultRestartPolicy(policy)
+}
+
+// OnModuleRestart 模块重启(或者超过重启次数放弃重启)时的hook，可以用于告警
+func OnModuleRestart(cb func(RestartEvent)) {
+	defaultManager.OnModuleRestart(cb)
+}
+
+// SetDefaultPanicPolicy 设置没有实现module.PanicIsolated的模块的panic策略，默认PanicContinue
+// 需要在模块初始化之前设置
+func (mgr *Manager) SetDefaultPanicPolicy(policy module.PanicPolicy) {
+	mgr.defaultPanicPolicy = policy
+}
+
+// OnModuleCrash 模块处理消息或者主循环panic时的hook，可以把崩溃报告转发到apm或者告警
+// 在panic的模块协程中执行，不要阻塞
+func (mgr *Manager) OnModuleCrash(cb func(module.CrashReport)) {
+	mgr.moduleCrashCb = cb
+}
+
+// SetDefaultPanicPolicy 设置没有实现module.PanicIsolated的模块的panic策略，默认PanicContinue
+func SetDefaultPanicPolicy(policy module.PanicPolicy) {
+	defaultManager.SetDefaultPanicPolicy(policy)
+}
+
+// OnModuleCrash 模块处理消息或者主循环panic时的hook，可以把崩溃报告转发到apm或者告警
+func OnModuleCrash(cb func(module.CrashReport)) {
+	defaultManager.OnModuleCrash(cb)
+}
+
+func (mgr *Manager) panicPolicyOf(mi module.Module) module.PanicPolicy {
+	if p, ok := mi.(module.PanicIsolated); ok {
+		return p.PanicPolicy()
+	}
+	return mgr.defaultPanicPolicy
+}
+
+func (mgr *Manager) fireCrashReport(report module.CrashReport) {
+	if mgr.moduleCrashCb == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.PanicStack("panic in module crash hook", r)
+		}
+	}()
+	mgr.moduleCrashCb(report)
+}
+
+func (mgr *Manager) restartPolicyOf(mi module.Module) module.RestartPolicy {
+	if s, ok := mi.(module.Supervised); ok {
+		return s.RestartPolicy()
+	}
+	return mgr.defaultRestartPolicy
+}
+
+// supervise 模块主循环退出之后，决定是否重启；需要重启时会等待backoff之后再返回true
+// panicPolicy是消息处理panic导致退出时模块的PanicPolicy
+func (m *mod) supervise(crashed bool, panicPolicy module.PanicPolicy, restarts int) bool {
+	if panicPolicy == module.PanicExit {
+		log.Error("module %s crashed with exit policy, shutting down server", m.mi.Name())
+		//Close会等待所有模块退出，不能在这里阻塞
+		go m.mgr.Close()
+		return false
+	}
+	policy := m.mgr.restartPolicyOf(m.mi)
+	switch policy.Mode {
+	case module.RestartAlways:
+	case module.RestartOnFailure:
+		if !crashed {
+			return false
+		}
+	default:
+		if panicPolicy != module.PanicRestart {
+			return false
+		}
+	}
+	_, reason := m.getState()
+	event := RestartEvent{
+		Name:     m.mi.Name(),
+		Restarts: restarts,
+		Reason:   reason,
+		Backoff:  policy.BackoffOf(restarts),
+	}
+	if policy.MaxRestarts > 0 && restarts > policy.MaxRestarts {
+		event.Exhausted = true
+		log.Error("module %s restarted %d times, give up", m.mi.Name(), policy.MaxRestarts)
+		m.mgr.fireRestartEvent(event)
+		if policy.ExitOnExhausted {
+			log.Error("module %s exhausted restart budget, shutting down server", m.mi.Name())
+			//Close会等待所有模块退出，不能在这里阻塞
+			go m.mgr.Close()
+		}
+		return false
+	}
+	log.Warn("module %s exit(%s), restart #%d after %v", m.mi.Name(), reason, restarts, event.Backoff)
+	//等待重启期间只影响readiness，restart中setState之后清除
+	m.setRestarting(true)
+	m.mgr.fireRestartEvent(event)
+	if event.Backoff <= 0 {
+		if m.ctx.Err() != nil {
+			m.setRestarting(false)
+			return false
+		}
+		return true
+	}
+	t := time.NewTimer(event.Backoff)
+	defer t.Stop()
+	select {
+	case <-m.ctx.Done():
+		m.setRestarting(false)
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (mgr *Manager) fireRestartEvent(event RestartEvent) {
+	if mgr.moduleRestartCb == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.PanicStack("panic in module restart hook", r)
+		}
+	}()
+	mgr.moduleRestartCb(event)
+}
+
+// restart 重新初始化模块，OnDestroy和OnInit中的panic会被当成一次崩溃
+func (m *mod) restart() (ok bool) {
+	m.setState(StateInitializing, "")
+	defer func() {
+		if r := recover(); r != nil {
+			log.PanicStack(fmt.Sprintf("panic when restart module %s", m.mi.Name()), r)
+			m.setState(StateCrashed, fmt.Sprintf("panic when restart: %v", r))
+		}
+	}()
+	m.mi.OnDestroy()
+	m.mi.OnInit()
+	log.Info("module restarted: %s", m.mi.Name())
+	return true
+}
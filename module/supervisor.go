@@ -0,0 +1,65 @@
+package module
+
+import "time"
+
+// RestartMode 模块主循环退出之后是否重启
+type RestartMode int
+
+const (
+	// RestartNever 不重启
+	RestartNever RestartMode = iota
+	// RestartAlways 只要不是被server关闭，退出就重启
+	RestartAlways
+	// RestartOnFailure 只有panic时才重启
+	RestartOnFailure
+)
+
+// RestartPolicy 模块的重启策略
+// 重启时会依次调用OnDestroy、OnInit和Run，模块需要保证OnInit可以重复调用
+type RestartPolicy struct {
+	Mode RestartMode
+	// MaxRestarts 最大重启次数，0表示不限制
+	MaxRestarts int
+	// Backoff 第一次重启前的等待时间，之后每次翻倍
+	Backoff time.Duration
+	// MaxBackoff 等待时间的上限，0表示不限制
+	MaxBackoff time.Duration
+	// ExitOnExhausted 超过最大重启次数之后关闭整个服务
+	ExitOnExhausted bool
+	// StableAfter 模块连续运行超过这个时间之后，重启次数和backoff重新计算，偶尔的崩溃不会耗尽重启次数
+	// 0表示默认的10分钟，小于0表示不重新计算
+	StableAfter time.Duration
+}
+
+// DefaultStableAfter RestartPolicy.StableAfter的默认值
+const DefaultStableAfter = 10 * time.Minute
+
+// Stable 连续运行了d之后是否重新计算重启次数
+func (p RestartPolicy) Stable(d time.Duration) bool {
+	stable := p.StableAfter
+	if stable == 0 {
+		stable = DefaultStableAfter
+	}
+	return stable > 0 && d >= stable
+}
+
+// BackoffOf 第n次(从1开始)重启前需要等待的时间
+func (p RestartPolicy) BackoffOf(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && d > 0; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Supervised 可选接口，声明模块自己的重启策略
+// 没有实现时使用server中设置的默认策略
+type Supervised interface {
+	RestartPolicy() RestartPolicy
+}
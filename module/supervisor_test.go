@@ -0,0 +1,27 @@
+package module
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicy_BackoffOf(t *testing.T) {
+	p := RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for n, expect := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := p.BackoffOf(n + 1); d != expect {
+			t.Fatalf("restart #%d: expect %v, got %v", n+1, expect, d)
+		}
+	}
+}
+
+func TestRestartPolicy_Stable(t *testing.T) {
+	if p := (RestartPolicy{}); p.Stable(time.Minute) || !p.Stable(DefaultStableAfter) {
+		t.Fatal("default stable period should be used")
+	}
+	if p := (RestartPolicy{StableAfter: time.Second}); !p.Stable(time.Second) || p.Stable(time.Millisecond) {
+		t.Fatal("unexpected stable result")
+	}
+	if p := (RestartPolicy{StableAfter: -1}); p.Stable(time.Hour) {
+		t.Fatal("negative StableAfter should never reset")
+	}
+}
@@ -1,26 +1,78 @@
 package module
 
 import (
-	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/base/structs/chanx"
+	"math/rand"
+	"sync"
 	"time"
 )
 
 // Dispatcher one per goroutine (goroutine not safe)
 type Dispatcher struct {
 	ChanTimer *chanx.UnboundedChan[*Timer]
+	clock     Clock
+	guard     *panicGuard
+	// randInt63n jitter的随机数来源，测试时可以替换
+	randInt63n func(n int64) int64
+
+	exitLock sync.Mutex
+	exited   chan struct{} //主循环退出时关闭，使用MockClock时不再等待回调执行
 }
 
 func NewDispatcher() *Dispatcher {
+	return NewDispatcherWithClock(RealClock)
+}
+
+// NewDispatcherWithClock 使用指定的时钟，测试时可以传入MockClock
+func NewDispatcherWithClock(clock Clock) *Dispatcher {
 	dp := new(Dispatcher)
 	dp.ChanTimer = chanx.NewUnboundedChan[*Timer](initBufferSize)
+	dp.clock = clock
+	dp.randInt63n = rand.Int63n
+	dp.exited = make(chan struct{})
 	return dp
 }
 
+// loopStarted 处理ChanTimer的主循环开始运行，模块重启时会再次调用
+func (dp *Dispatcher) loopStarted() {
+	dp.exitLock.Lock()
+	defer dp.exitLock.Unlock()
+	select {
+	case <-dp.exited:
+		dp.exited = make(chan struct{})
+	default:
+	}
+}
+
+// loopExited 主循环退出，之后到期的定时器不会再执行
+func (dp *Dispatcher) loopExited() {
+	dp.exitLock.Lock()
+	defer dp.exitLock.Unlock()
+	select {
+	case <-dp.exited:
+	default:
+		close(dp.exited)
+	}
+}
+
+func (dp *Dispatcher) exitedChan() <-chan struct{} {
+	dp.exitLock.Lock()
+	defer dp.exitLock.Unlock()
+	return dp.exited
+}
+
+// Clock 当前使用的时钟
+func (dp *Dispatcher) Clock() Clock {
+	return dp.clock
+}
+
 // Timer 定时器
 type Timer struct {
-	t  *time.Timer
+	t  ClockTimer
 	cb func()
+	// 使用MockClock时，回调执行完毕后关闭，让MockClock.Advance可以等待回调执行
+	done chan struct{}
+	dp   *Dispatcher
 }
 
 func (t *Timer) Stop() {
@@ -31,8 +83,15 @@ func (t *Timer) Stop() {
 func (t *Timer) Cb() {
 	defer func() {
 		t.cb = nil
+		var report *CrashReport
 		if r := recover(); r != nil {
-			log.PanicStack("", r)
+			report = t.dp.guard.recovered(TimerCallId, nil, r)
+		}
+		if t.done != nil {
+			close(t.done)
+		}
+		if report != nil {
+			report.escalate()
 		}
 	}()
 
@@ -44,12 +103,31 @@ func (t *Timer) Cb() {
 func (dp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
+	t.dp = dp
+	if _, ok := dp.clock.(*MockClock); ok {
+		t.done = make(chan struct{})
+	}
+	t.t = dp.clock.AfterFunc(d, func() {
 		dp.ChanTimer.In <- t
+		if t.done != nil {
+			//主循环已经退出时回调不会再执行，不能一直等待，否则MockClock.Advance会死锁
+			select {
+			case <-t.done:
+			case <-dp.exitedChan():
+			}
+		}
 	})
 	return t
 }
 
+// jitter 随机的延迟，范围是[0, max)
+func (dp *Dispatcher) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(dp.randInt63n(int64(max)))
+}
+
 type Cron struct {
 	t *Timer
 }
@@ -60,10 +138,24 @@ func (c *Cron) Stop() {
 	}
 }
 
-func (dp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
+// CronFunc 按cron表达式定时执行cb
+// jitter>0时每次执行会随机延后[0, jitter)，避免多个节点在同一时刻执行，jitter应该小于执行间隔
+func (dp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func(), jitter ...time.Duration) *Cron {
+	return dp.cronFunc(cronExpr, func(time.Time) { _cb() }, jitter...)
+}
+
+// cronFunc 同CronFunc，回调的参数是本次计划执行的时间(不含jitter)
+func (dp *Dispatcher) cronFunc(cronExpr *CronExpr, _cb func(at time.Time), jitter ...time.Duration) *Cron {
 	c := new(Cron)
+	var maxJitter time.Duration
+	if len(jitter) > 0 {
+		maxJitter = jitter[0]
+	}
+	delay := func(nextTime, now time.Time) time.Duration {
+		return nextTime.Sub(now) + dp.jitter(maxJitter)
+	}
 
-	now := time.Now()
+	now := dp.clock.Now()
 	nextTime := cronExpr.Next(now)
 	if nextTime.IsZero() {
 		return c
@@ -72,16 +164,21 @@ func (dp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	// callback
 	var cb func()
 	cb = func() {
-		defer _cb()
+		at := nextTime
+		defer _cb(at)
 
-		now := time.Now()
-		nextTime := cronExpr.Next(now)
+		now := dp.clock.Now()
+		//系统时钟回拨时now可能早于at，从at开始计算，避免同一个时间点重复执行
+		if now.Before(at) {
+			now = at
+		}
+		nextTime = cronExpr.Next(now)
 		if nextTime.IsZero() {
 			return
 		}
-		c.t = dp.AfterFunc(nextTime.Sub(now), cb)
+		c.t = dp.AfterFunc(delay(nextTime, dp.clock.Now()), cb)
 	}
 
-	c.t = dp.AfterFunc(nextTime.Sub(now), cb)
+	c.t = dp.AfterFunc(delay(nextTime, now), cb)
 	return c
 }
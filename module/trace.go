@@ -0,0 +1,97 @@
+package module
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+/**  rpc调用的上下文传递和追踪
+  *  调用方的ctx随请求进入模块，handler中用CallContext取回，继续调用其他模块时传下去即可
+  *  设置CallTracer之后每个消息都会创建span，apm.NewModuleTracer是基于OpenTelemetry的实现
+**/
+
+// CallTracer rpc调用的追踪
+type CallTracer interface {
+	// StartCall 在模块协程中执行消息之前调用，返回的ctx会作为handler的CallContext
+	// end在消息执行完毕之后调用，handler panic时err不为nil
+	StartCall(ctx context.Context, module string, id any) (context.Context, func(err error))
+}
+
+type tracerHolder struct {
+	t CallTracer
+}
+
+var callTracer atomic.Value
+
+// SetCallTracer 设置所有模块使用的CallTracer，传nil取消追踪，goroutine safe
+func SetCallTracer(t CallTracer) {
+	callTracer.Store(tracerHolder{t: t})
+}
+
+func loadCallTracer() CallTracer {
+	h, _ := callTracer.Load().(tracerHolder)
+	return h.t
+}
+
+func noopEnd(error) {}
+
+// startCall 开始执行消息，返回handler使用的ctx
+func (s *RpcServer) startCall(ci *callInfo) (context.Context, func(error)) {
+	ctx := ci.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t := loadCallTracer()
+	if t == nil {
+		return ctx, noopEnd
+	}
+	var name string
+	if s.guard != nil {
+		name = s.guard.module
+	}
+	return t.StartCall(ctx, name, ci.id)
+}
+
+// CallContext 当前正在执行的消息的上下文，只能在handler中(模块协程)调用
+// 没有在执行消息时返回context.Background()
+func (s *RpcServer) CallContext() context.Context {
+	if s.current == nil {
+		return context.Background()
+	}
+	return s.current
+}
+
+// GoContext 同Go，ctx随请求传给handler，只用于传递追踪等信息，ctx取消不会影响已经入队的请求执行
+// 邮箱策略为MailboxBlock时，ctx取消会停止等待并丢弃请求，goroutine safe
+func (s *RpcServer) GoContext(ctx context.Context, id any, args ...any) {
+	s.goWithContext(ctx, s.priorityOf(id), id, args)
+}
+
+// AsyncCallContext 同AsyncCall，ctx随请求传给handler，ctx取消之后不再执行，回调收到CallTimeoutError
+func (c *RpcClient) AsyncCallContext(ctx context.Context, id any, _args ...any) {
+	c.asyncCallContext(ctx, id, _args)
+}
+
+// AsyncCallContext 同AsyncCall，ctx随请求传给handler
+func (s *GoroutineMixIn) AsyncCallContext(ctx context.Context, server *RpcServer, id any, args ...any) {
+	s.rpcClient.Attach(server)
+	s.rpcClient.AsyncCallContext(ctx, id, args...)
+}
+
+// detachedContext 只保留ctx中的值，不会被取消
+type detachedContext struct {
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	if ctx == nil || ctx.Done() == nil {
+		return ctx
+	}
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
@@ -0,0 +1,80 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type ctxKey struct{}
+
+type fakeTracer struct {
+	started []string
+	errs    []error
+}
+
+func (t *fakeTracer) StartCall(ctx context.Context, module string, id any) (context.Context, func(error)) {
+	t.started = append(t.started, fmt.Sprintf("%s/%v:%v", module, id, ctx.Value(ctxKey{})))
+	return context.WithValue(ctx, ctxKey{}, "span"), func(err error) {
+		t.errs = append(t.errs, err)
+	}
+}
+
+func TestRpcServer_CallTracer(t *testing.T) {
+	tracer := &fakeTracer{}
+	SetCallTracer(tracer)
+	defer SetCallTracer(nil)
+	s := NewRpcServer()
+	s.SetPanicPolicy("m", PanicContinue, nil)
+	var got []any
+	s.Register("f", func([]any) {
+		got = append(got, s.CallContext().Value(ctxKey{}))
+	})
+	s.Register("boom", func([]any) { panic("boom") })
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "req"))
+	s.GoContext(ctx, "f")
+	//Go只传递信息，取消之后仍然执行
+	cancel()
+	s.execIgnoreError(<-s.ChanCall.Out)
+	s.Go("boom")
+	s.execIgnoreError(<-s.ChanCall.Out)
+	if s.CallContext() != context.Background() {
+		t.Fatal("call context should be reset after exec")
+	}
+	if len(got) != 1 || got[0] != "span" {
+		t.Fatalf("unexpected call context %v", got)
+	}
+	if len(tracer.started) != 2 || tracer.started[0] != "m/f:req" || tracer.started[1] != "m/boom:<nil>" {
+		t.Fatalf("unexpected spans %v", tracer.started)
+	}
+	if tracer.errs[0] != nil || tracer.errs[1] == nil {
+		t.Fatalf("unexpected span errors %v", tracer.errs)
+	}
+}
+
+func TestRpcClient_AsyncCallContext(t *testing.T) {
+	s := NewRpcServer()
+	s.Register("f", func([]any) any {
+		return s.CallContext().Value(ctxKey{})
+	})
+	c := NewRpcClient(false)
+	c.Attach(s)
+	var got []any
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "req"))
+	c.AsyncCallContext(ctx, "f", func(ret any, err error) {
+		got = append(got, ret, err)
+	})
+	s.execIgnoreError(<-s.ChanCall.Out)
+	c.cb(<-c.chanAsyncRet.Out)
+	//取消之后不再执行
+	c.AsyncCallContext(ctx, "f", func(ret any, err error) {
+		got = append(got, ret, err)
+	})
+	cancel()
+	s.execIgnoreError(<-s.ChanCall.Out)
+	c.cb(<-c.chanAsyncRet.Out)
+	if len(got) != 4 || got[0] != "req" || got[1] != nil || got[3] == nil {
+		t.Fatalf("unexpected results %v", got)
+	}
+}
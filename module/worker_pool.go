@@ -0,0 +1,200 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"github.com/YiuTerran/go-common/base/log"
+	"sync"
+	"sync/atomic"
+)
+
+/**  介于Go(每次一个协程)和LinearContext(全部串行)之间的协程池
+  *  固定数量的worker和有界队列，key相同的任务串行执行，key不同的任务并行执行
+  *  和Go一样，cb在模块协程中执行
+**/
+
+var (
+	// ErrWorkerPoolFull 队列已满
+	ErrWorkerPoolFull = errors.New("worker pool full")
+	// ErrWorkerPoolClosed 协程池已经关闭
+	ErrWorkerPoolClosed = errors.New("worker pool closed")
+)
+
+type poolJob struct {
+	key any
+	f   func()
+	cb  func()
+}
+
+// WorkerPool 协程池，goroutine safe
+type WorkerPool struct {
+	g        *CallbackChn
+	capacity int
+
+	mu      sync.Mutex
+	cond    *sync.Cond //有任务可以执行，或者关闭
+	space   *sync.Cond //队列有空位，或者关闭
+	ready   []*poolJob
+	running map[any][]*poolJob //正在执行的key -> 等待执行的同key任务
+	queued  int
+	closed  bool
+	wg      sync.WaitGroup
+
+	executed int64
+}
+
+// NewWorkerPool workers个协程，最多queueSize个任务在队列中等待，queueSize<=0时不限制
+func (g *CallbackChn) NewWorkerPool(workers, queueSize int) *WorkerPool {
+	if workers <= 0 {
+		panic("worker pool needs at least 1 worker")
+	}
+	p := &WorkerPool{
+		g:        g,
+		capacity: queueSize,
+		running:  make(map[any][]*poolJob),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.space = sync.NewCond(&p.mu)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit 提交任务，key相同的任务按提交顺序串行执行，key为nil时不保证顺序
+// f在worker中执行，f执行完之后cb在模块协程中执行；队列满时返回ErrWorkerPoolFull
+func (p *WorkerPool) Submit(key any, f func(), cb func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrWorkerPoolClosed
+	}
+	if p.full() {
+		return ErrWorkerPoolFull
+	}
+	p.push(&poolJob{key: key, f: f, cb: cb})
+	return nil
+}
+
+// SubmitContext 同Submit，队列满时阻塞直到有空位，ctx结束时返回ctx.Err()
+// 不要在worker中调用，否则可能死锁
+func (p *WorkerPool) SubmitContext(ctx context.Context, key any, f func(), cb func()) error {
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				p.mu.Lock()
+				p.space.Broadcast()
+				p.mu.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.closed && p.full() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.space.Wait()
+	}
+	if p.closed {
+		return ErrWorkerPoolClosed
+	}
+	p.push(&poolJob{key: key, f: f, cb: cb})
+	return nil
+}
+
+func (p *WorkerPool) full() bool {
+	return p.capacity > 0 && p.queued >= p.capacity
+}
+
+// push 需要持有锁
+func (p *WorkerPool) push(j *poolJob) {
+	atomic.AddInt32(&p.g.pendingGo, 1)
+	p.queued++
+	if j.key != nil {
+		if pending, ok := p.running[j.key]; ok {
+			p.running[j.key] = append(pending, j)
+			return
+		}
+		p.running[j.key] = nil
+	}
+	p.ready = append(p.ready, j)
+	p.cond.Signal()
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	p.mu.Lock()
+	for {
+		for len(p.ready) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.ready) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		j := p.ready[0]
+		p.ready[0] = nil
+		p.ready = p.ready[1:]
+		p.queued--
+		p.space.Signal()
+		p.mu.Unlock()
+
+		p.run(j)
+
+		p.mu.Lock()
+		if j.key != nil {
+			//同key的下一个任务可以执行了
+			if pending := p.running[j.key]; len(pending) > 0 {
+				p.running[j.key] = pending[1:]
+				p.ready = append(p.ready, pending[0])
+			} else {
+				delete(p.running, j.key)
+			}
+		}
+	}
+}
+
+func (p *WorkerPool) run(j *poolJob) {
+	defer func() {
+		atomic.AddInt64(&p.executed, 1)
+		p.g.ChanCb.In <- j.cb
+		if r := recover(); r != nil {
+			log.PanicStack("", r)
+		}
+	}()
+	j.f()
+}
+
+// Len 在队列中等待执行的任务数
+func (p *WorkerPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued
+}
+
+// Executed 已经执行完的任务数
+func (p *WorkerPool) Executed() int64 {
+	return atomic.LoadInt64(&p.executed)
+}
+
+// Close 不再接收新的任务，等待队列中的任务执行完毕，cb仍然会在模块协程中执行
+// 不要在worker中调用
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.space.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// NewWorkerPool 创建协程池，任务的cb在模块协程中执行
+func (s *GoroutineMixIn) NewWorkerPool(workers, queueSize int) *WorkerPool {
+	return s.g.NewWorkerPool(workers, queueSize)
+}
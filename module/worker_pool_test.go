@@ -0,0 +1,120 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_KeyOrdering(t *testing.T) {
+	g := NewCallbackChn()
+	p := g.NewWorkerPool(4, 0)
+	var mu sync.Mutex
+	order := make(map[int][]int)
+	var running, maxRunning int
+	for i := 0; i < 100; i++ {
+		key, seq := i%5, i
+		if err := p.Submit(key, func() {
+			mu.Lock()
+			order[key] = append(order[key], seq)
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+	g.Close()
+	for key, seqs := range order {
+		if len(seqs) != 20 {
+			t.Fatalf("key %d: expect 20 jobs, got %d", key, len(seqs))
+		}
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] < seqs[i-1] {
+				t.Fatalf("key %d: out of order %v", key, seqs)
+			}
+		}
+	}
+	if maxRunning > 4 {
+		t.Fatalf("expect at most 4 workers, got %d", maxRunning)
+	}
+	if p.Executed() != 100 || !g.Idle() {
+		t.Fatalf("unexpected executed %d", p.Executed())
+	}
+}
+
+func TestWorkerPool_Bounded(t *testing.T) {
+	g := NewCallbackChn()
+	p := g.NewWorkerPool(1, 1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	if err := p.Submit(nil, func() { close(started); <-block }, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := p.Submit(nil, func() {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Submit(nil, func() {}, nil); !errors.Is(err, ErrWorkerPoolFull) {
+		t.Fatalf("expect full, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.SubmitContext(ctx, nil, func() {}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- p.SubmitContext(context.Background(), nil, func() {}, nil)
+	}()
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if err := p.Submit(nil, func() {}, nil); !errors.Is(err, ErrWorkerPoolClosed) {
+		t.Fatalf("expect closed, got %v", err)
+	}
+	g.Close()
+	if p.Executed() != 3 {
+		t.Fatalf("expect 3 executed, got %d", p.Executed())
+	}
+}
+
+func TestWorkerPool_CallbackOnModule(t *testing.T) {
+	s := NewGoroutineMixIn()
+	p := s.NewWorkerPool(2, 0)
+	s.Register("submit", func(args []any) any {
+		ch := make(chan int, 1)
+		_ = p.Submit("k", func() { panic("boom") }, func() { ch <- 1 })
+		return ch
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	ret, err := s.Call1("submit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//panic之后cb仍然会在模块协程中执行
+	select {
+	case <-ret.(chan int):
+	case <-time.After(time.Second):
+		t.Fatal("callback not executed")
+	}
+	p.Close()
+	cancel()
+	<-done
+}
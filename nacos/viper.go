@@ -2,14 +2,24 @@ package nacos
 
 import (
 	"github.com/spf13/viper"
+	"reflect"
 	"sync"
 )
 
 // 默认情况下viper读入配置并不是并发安全的，这里简单的包装下
 
 type SafeViper struct {
-	lock  sync.RWMutex
-	viper *viper.Viper
+	lock     sync.RWMutex
+	viper    *viper.Viper
+	watchers []*sectionWatcher
+}
+
+// sectionWatcher 监听配置中的一段，只有这一段变化时才回调
+type sectionWatcher struct {
+	lock sync.Mutex
+	key  string
+	last map[string]any
+	cb   func(map[string]any)
 }
 
 func (sv *SafeViper) Load() *viper.Viper {
@@ -21,5 +31,33 @@ func (sv *SafeViper) Load() *viper.Viper {
 func (sv *SafeViper) Store(vp *viper.Viper) {
 	sv.lock.Lock()
 	sv.viper = vp
+	watchers := sv.watchers
+	sv.lock.Unlock()
+	for _, w := range watchers {
+		w.notify(vp)
+	}
+}
+
+// WatchSection 监听key对应的配置段，注册时如果已经有配置会立即回调一次，之后这一段配置变化时回调
+// 回调在nacos的监听协程中执行，不要阻塞；比如可以传入server.ConfigModules.Update实现模块热加载
+func (sv *SafeViper) WatchSection(key string, cb func(section map[string]any)) {
+	w := &sectionWatcher{key: key, cb: cb}
+	sv.lock.Lock()
+	sv.watchers = append(sv.watchers, w)
+	vp := sv.viper
 	sv.lock.Unlock()
+	if vp != nil {
+		w.notify(vp)
+	}
+}
+
+func (w *sectionWatcher) notify(vp *viper.Viper) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	section := vp.GetStringMap(w.key)
+	if w.last != nil && reflect.DeepEqual(w.last, section) {
+		return
+	}
+	w.last = section
+	w.cb(section)
 }
@@ -0,0 +1,264 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// localServer 代替本地模块的rpc.IServer
+type localServer struct {
+	running    int32
+	maxRunning int32
+	release    chan struct{}
+}
+
+func (s *localServer) Go(id any, args ...any) {}
+
+func (s *localServer) Call0(id any, args ...any) error {
+	_, err := s.Call1(id, args...)
+	return err
+}
+
+func (s *localServer) Call1(id any, args ...any) (any, error) {
+	n := atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+	for {
+		max := atomic.LoadInt32(&s.maxRunning)
+		if n <= max || atomic.CompareAndSwapInt32(&s.maxRunning, max, n) {
+			break
+		}
+	}
+	switch id {
+	case "add":
+		return args[0].(float64) + args[1].(float64), nil
+	case "block":
+		<-s.release
+		return nil, nil
+	}
+	return nil, errors.New("unknown function")
+}
+
+func (s *localServer) CallN(id any, args ...any) ([]any, error) {
+	ret, err := s.Call1(id, args...)
+	return []any{ret}, err
+}
+
+// ctxServer 支持ctx的localServer，block在ctx结束之后返回
+type ctxServer struct {
+	*localServer
+	canceled chan error
+}
+
+func withContext(cs **ctxServer) func(*Server) {
+	return func(s *Server) {
+		*cs = &ctxServer{localServer: s.RPCServer.(*localServer), canceled: make(chan error, 1)}
+		s.RPCServer = *cs
+	}
+}
+
+func (s *ctxServer) Call0Context(ctx context.Context, id any, args ...any) error {
+	_, err := s.Call1Context(ctx, id, args...)
+	return err
+}
+
+func (s *ctxServer) Call1Context(ctx context.Context, id any, args ...any) (any, error) {
+	if id != "block" {
+		return s.Call1(id, args...)
+	}
+	atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+	select {
+	case <-s.release:
+		return nil, nil
+	case <-ctx.Done():
+		s.canceled <- ctx.Err()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *ctxServer) CallNContext(ctx context.Context, id any, args ...any) ([]any, error) {
+	ret, err := s.Call1Context(ctx, id, args...)
+	return []any{ret}, err
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// loopback 启动服务端和客户端，等待连接成功
+func loopback(t *testing.T, maxCalls int, options ...func(*Server)) (*localServer, *Client, context.CancelFunc) {
+	local := &localServer{release: make(chan struct{})}
+	server := &Server{Addr: freeAddr(t), RPCServer: local, MaxConcurrentCalls: maxCalls}
+	for _, option := range options {
+		option(server)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Run(ctx)
+	}()
+	c := NewClient(server.Addr, nil, ConnectInterval(10*time.Millisecond), CallTimeout(time.Second))
+	c.Start()
+	deadline := time.Now().Add(3 * time.Second)
+	for !c.Connected() {
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return local, c, func() {
+		c.Close()
+		cancel()
+	}
+}
+
+func TestServer_RunError(t *testing.T) {
+	if err := new(Server).Run(context.Background()); err == nil {
+		t.Fatal("expect error without addr")
+	}
+	if err := (&Server{Addr: freeAddr(t)}).Run(context.Background()); err == nil {
+		t.Fatal("expect error without RPCServer")
+	}
+}
+
+func TestClient_Call(t *testing.T) {
+	_, c, stop := loopback(t, 0)
+	defer stop()
+	ret, err := c.Call1("add", 1, 2)
+	if err != nil || ret != float64(3) {
+		t.Fatalf("expect 3, got %v, %v", ret, err)
+	}
+	var re *RemoteError
+	if err = c.Call0("unknown"); !errors.As(err, &re) {
+		t.Fatalf("expect remote error, got %v", err)
+	}
+	if _, err = c.Call1(1); err == nil {
+		t.Fatal("expect error for non-string id")
+	}
+}
+
+func TestClient_CallTimeout(t *testing.T) {
+	local, c, stop := loopback(t, 0)
+	defer stop()
+	defer close(local.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Call0Context(ctx, "block"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
+
+func TestClient_ClosePending(t *testing.T) {
+	local, c, stop := loopback(t, 0)
+	defer stop()
+	defer close(local.release)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Call0("block")
+	}()
+	for atomic.LoadInt32(&local.running) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Close()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrClientClosed) {
+			t.Fatalf("expect client closed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call not failed after close")
+	}
+	if err := c.Call0("add", 1, 2); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("expect client closed, got %v", err)
+	}
+}
+
+func TestServer_MaxConcurrentCalls(t *testing.T) {
+	local, c, stop := loopback(t, 2)
+	defer stop()
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			errs <- c.Call0("block")
+		}()
+	}
+	for atomic.LoadInt32(&local.running) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	//超过并发数的调用在读循环中等待
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&local.running); n != 2 {
+		t.Fatalf("expect 2 calls running, got %d", n)
+	}
+	close(local.release)
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if max := atomic.LoadInt32(&local.maxRunning); max != 2 {
+		t.Fatalf("expect at most 2 calls running, got %d", max)
+	}
+}
+
+func TestServer_CallContext(t *testing.T) {
+	var cs *ctxServer
+	_, c, stop := loopback(t, 0, withContext(&cs))
+	defer stop()
+	waitCanceled := func(target error) {
+		select {
+		case err := <-cs.canceled:
+			if !errors.Is(err, target) {
+				t.Fatalf("expect %v, got %v", target, err)
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("server call not canceled")
+		}
+	}
+	//调用方的超时时间会带给服务端
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Call0Context(ctx, "block"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	waitCanceled(context.DeadlineExceeded)
+
+	//连接断开之后取消正在执行的调用
+	go func() {
+		_ = c.Call0("block")
+	}()
+	for atomic.LoadInt32(&cs.running) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Close()
+	waitCanceled(context.Canceled)
+}
+
+func TestServer_CallTimeout(t *testing.T) {
+	var cs *ctxServer
+	_, c, stop := loopback(t, 0, withContext(&cs), func(s *Server) {
+		s.CallTimeout = 50 * time.Millisecond
+	})
+	defer stop()
+	var re *RemoteError
+	if err := c.Call0("block"); !errors.As(err, &re) {
+		t.Fatalf("expect remote timeout error, got %v", err)
+	}
+	if err := <-cs.canceled; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package chanrpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"github.com/YiuTerran/go-common/network"
+	"github.com/YiuTerran/go-common/network/tcp"
+	"sync"
+	"time"
+)
+
+// Client 远程模块的客户端，实现了rpc.IServer，可以代替本地模块的RPC()使用
+// 断线之后会自动重连，断线期间的调用直接返回ErrNotConnected
+type Client struct {
+	Addr            string
+	MsgProcessor    network.MsgProcessor
+	BinaryParser    tcp.IParser
+	ConnectInterval time.Duration
+	// CallTimeout 同步调用的默认超时时间，ctx已经有deadline时不生效，<=0时不超时
+	CallTimeout time.Duration
+
+	tcpClient *tcp.Client
+	mu        sync.Mutex
+	session   *clientSession
+	seq       uint64
+	pending   map[uint64]*pendingCall
+	closed    bool
+}
+
+type Option func(*Client)
+
+type callResult struct {
+	resp *CallResponse
+	err  error
+}
+
+type pendingCall struct {
+	session *clientSession
+	ch      chan callResult
+}
+
+// NewClient 创建客户端，processor为空时使用NewJsonProcessor，需要调用Start开始连接
+func NewClient(addr string, processor network.MsgProcessor, options ...Option) *Client {
+	if processor == nil {
+		processor = NewJsonProcessor()
+	}
+	c := &Client{
+		Addr:            addr,
+		MsgProcessor:    processor,
+		ConnectInterval: 3 * time.Second,
+		CallTimeout:     10 * time.Second,
+		pending:         make(map[uint64]*pendingCall),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func Parser(p tcp.IParser) Option {
+	return func(c *Client) {
+		c.BinaryParser = p
+	}
+}
+
+func ConnectInterval(dr time.Duration) Option {
+	return func(c *Client) {
+		c.ConnectInterval = dr
+	}
+}
+
+func CallTimeout(dr time.Duration) Option {
+	return func(c *Client) {
+		c.CallTimeout = dr
+	}
+}
+
+// Start 开始连接服务端，非阻塞
+func (c *Client) Start() {
+	c.tcpClient = &tcp.Client{
+		Addr:            c.Addr,
+		ConnNum:         1,
+		ConnectInterval: c.ConnectInterval,
+		AutoReconnect:   true,
+		Parser:          c.BinaryParser,
+		NewAgentFunc: func(conn *tcp.Conn) network.Session {
+			ss := &clientSession{conn: conn, c: c}
+			c.mu.Lock()
+			c.session = ss
+			c.mu.Unlock()
+			return ss
+		},
+	}
+	c.tcpClient.Start()
+}
+
+// Close 关闭连接，等待中的调用返回ErrClientClosed，可以重复调用
+func (c *Client) Close() {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+	//先结束等待中的调用，否则断线时会返回ErrNotConnected
+	c.failPending(nil, ErrClientClosed)
+	if c.tcpClient != nil {
+		c.tcpClient.Close()
+	}
+}
+
+// Connected 当前是否已经连接上服务端
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session != nil
+}
+
+func (c *Client) Go(id any, args ...any) {
+	if _, err := c.call(context.Background(), CallGo, id, args); err != nil {
+		log.Error("chanrpc go %v error: %v", id, err)
+	}
+}
+
+func (c *Client) Call0(id any, args ...any) error {
+	return c.Call0Context(context.Background(), id, args...)
+}
+
+func (c *Client) Call1(id any, args ...any) (any, error) {
+	return c.Call1Context(context.Background(), id, args...)
+}
+
+func (c *Client) CallN(id any, args ...any) ([]any, error) {
+	return c.CallNContext(context.Background(), id, args...)
+}
+
+// Call0Context 带上下文的Call0，ctx结束后不再等待结果，但是服务端可能已经执行了
+func (c *Client) Call0Context(ctx context.Context, id any, args ...any) error {
+	_, err := c.call(ctx, Call0, id, args)
+	return err
+}
+
+// Call1Context 带上下文的Call1
+func (c *Client) Call1Context(ctx context.Context, id any, args ...any) (any, error) {
+	resp, err := c.call(ctx, Call1, id, args)
+	if err != nil || len(resp.Ret) == 0 {
+		return nil, err
+	}
+	return resp.Ret[0], nil
+}
+
+// CallNContext 带上下文的CallN
+func (c *Client) CallNContext(ctx context.Context, id any, args ...any) ([]any, error) {
+	resp, err := c.call(ctx, CallN, id, args)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ret, nil
+}
+
+func (c *Client) call(ctx context.Context, typ CallType, id any, args []any) (*CallResponse, error) {
+	sid, ok := id.(string)
+	if !ok {
+		return nil, fmt.Errorf("remote function id %v: only string id supported", id)
+	}
+	if _, ok = ctx.Deadline(); !ok && c.CallTimeout > 0 && typ != CallGo {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.CallTimeout)
+		defer cancel()
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	ss := c.session
+	if ss == nil {
+		c.mu.Unlock()
+		return nil, ErrNotConnected
+	}
+	c.seq++
+	req := &CallRequest{Seq: c.seq, Type: typ, Id: sid, Args: args}
+	if deadline, ok := ctx.Deadline(); ok && typ != CallGo {
+		//把剩余时间带给服务端，超时之后服务端也不再等待
+		if req.Timeout = time.Until(deadline).Milliseconds(); req.Timeout <= 0 {
+			req.Timeout = 1
+		}
+	}
+	pc := &pendingCall{session: ss, ch: make(chan callResult, 1)}
+	if typ != CallGo {
+		c.pending[req.Seq] = pc
+	}
+	c.mu.Unlock()
+
+	if err := writeMsg(ss.conn, c.MsgProcessor, req); err != nil {
+		c.removePending(req.Seq)
+		return nil, err
+	}
+	if typ == CallGo {
+		return nil, nil
+	}
+	select {
+	case r := <-pc.ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.resp.Err != "" {
+			return nil, &RemoteError{Id: sid, Msg: r.resp.Err}
+		}
+		return r.resp, nil
+	case <-ctx.Done():
+		c.removePending(req.Seq)
+		return nil, fmt.Errorf("remote function id %s: %w", sid, ctx.Err())
+	}
+}
+
+func (c *Client) removePending(seq uint64) {
+	c.mu.Lock()
+	delete(c.pending, seq)
+	c.mu.Unlock()
+}
+
+func (c *Client) dispatch(resp *CallResponse) {
+	c.mu.Lock()
+	pc, ok := c.pending[resp.Seq]
+	delete(c.pending, resp.Seq)
+	c.mu.Unlock()
+	if ok {
+		pc.ch <- callResult{resp: resp}
+	}
+}
+
+// failPending 连接断开时结束这个连接上等待的调用，ss为空时结束所有调用
+func (c *Client) failPending(ss *clientSession, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ss == nil || c.session == ss {
+		c.session = nil
+	}
+	for seq, pc := range c.pending {
+		if ss == nil || pc.session == ss {
+			delete(c.pending, seq)
+			pc.ch <- callResult{err: err}
+		}
+	}
+}
+
+type clientSession struct {
+	conn network.Conn
+	c    *Client
+}
+
+func (cs *clientSession) Run() {
+	for {
+		data, err := cs.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message error: %v", err)
+			break
+		}
+		if len(data) == 0 {
+			continue
+		}
+		msg, err := cs.c.MsgProcessor.Unmarshal(data)
+		if err != nil {
+			log.Debug("unmarshal message error: %v", err)
+			break
+		}
+		resp, ok := msg.(*CallResponse)
+		if !ok {
+			log.Warn("unexpected chanrpc message %T from %v", msg, cs.conn.RemoteAddr())
+			continue
+		}
+		cs.c.dispatch(resp)
+	}
+}
+
+func (cs *clientSession) OnClose() {
+	cs.c.failPending(cs, ErrNotConnected)
+}
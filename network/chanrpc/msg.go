@@ -0,0 +1,75 @@
+package chanrpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/YiuTerran/go-common/network"
+	"github.com/YiuTerran/go-common/network/processor"
+)
+
+/**  跨进程的chanrpc，把模块注册的函数通过tcp暴露给其他进程
+  *  服务端(Server)把请求转发给本地模块的rpc.IServer，客户端(Client)本身也实现了rpc.IServer
+  *  所以调用方不需要关心模块是在本进程还是在远程
+**/
+
+// CallType 调用方式，对应rpc.IServer的各个方法
+type CallType int
+
+const (
+	CallGo CallType = iota
+	Call0
+	Call1
+	CallN
+)
+
+var (
+	// ErrNotConnected 还没有连接上服务端，或者连接已经断开
+	ErrNotConnected = errors.New("chanrpc remote not connected")
+	// ErrClientClosed 客户端已经关闭
+	ErrClientClosed = errors.New("chanrpc client closed")
+)
+
+// CallRequest 调用请求
+// 参数和返回值需要能被MsgProcessor序列化，比如json反序列化之后数字都是float64，结构体都是map
+type CallRequest struct {
+	Seq  uint64   `json:"seq"`
+	Type CallType `json:"type"`
+	// Id 远程调用的函数id只支持string
+	Id   string `json:"id"`
+	Args []any  `json:"args,omitempty"`
+	// Timeout 调用方剩余的超时时间(毫秒)，0表示不限制，服务端超时之后不再等待模块的结果
+	Timeout int64 `json:"timeout,omitempty"`
+}
+
+// CallResponse 调用结果，CallGo没有返回
+type CallResponse struct {
+	Seq uint64 `json:"seq"`
+	Ret []any  `json:"ret,omitempty"`
+	Err string `json:"err,omitempty"`
+}
+
+// RemoteError 服务端返回的错误
+type RemoteError struct {
+	Id  string
+	Msg string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote function id %s: %s", e.Id, e.Msg)
+}
+
+// NewJsonProcessor 注册了CallRequest和CallResponse的json处理器，服务端和客户端需要使用相同的处理器
+func NewJsonProcessor() *processor.JsonProcessor {
+	p := processor.NewProcessor()
+	p.Register(&CallRequest{})
+	p.Register(&CallResponse{})
+	return p
+}
+
+func writeMsg(conn network.Conn, p network.MsgProcessor, msg any) error {
+	data, err := p.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMsg(data...)
+}
@@ -0,0 +1,187 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"github.com/YiuTerran/go-common/base/log"
+	"github.com/YiuTerran/go-common/base/structs/rpc"
+	"github.com/YiuTerran/go-common/network"
+	"github.com/YiuTerran/go-common/network/tcp"
+	"time"
+)
+
+// Server 把本地模块的RPC暴露给其他进程
+// 可以作为模块的一部分在Run中运行，也可以单独起一个模块
+type Server struct {
+	//监听地址
+	Addr string
+	//最大连接数
+	MaxConnNum int
+	//消息处理器，为空时使用NewJsonProcessor
+	MsgProcessor network.MsgProcessor
+	//二进制分包，为空时使用tcp默认的分包
+	BinaryParser tcp.IParser
+	//被调用的本地模块，一般是module.RPC()
+	RPCServer rpc.IServer
+	//每个连接同时执行的同步调用数，超过时暂停读取这个连接的请求，默认64
+	MaxConcurrentCalls int
+	//同步调用的超时时间，<=0表示不限制；请求带了超时时间时取较小的
+	//只有RPCServer实现了ContextServer时才生效
+	CallTimeout time.Duration
+
+	callServer ContextServer
+}
+
+// ContextServer 支持ctx的rpc.IServer，module.RpcServer实现了这个接口
+// 连接断开或者超时之后，还在排队的调用不再执行，调用方也不再等待结果
+type ContextServer interface {
+	Call0Context(ctx context.Context, id any, args ...any) error
+	Call1Context(ctx context.Context, id any, args ...any) (any, error)
+	CallNContext(ctx context.Context, id any, args ...any) ([]any, error)
+}
+
+// noContextServer 不支持ctx的rpc.IServer，忽略ctx
+type noContextServer struct {
+	rpc.IServer
+}
+
+func (s noContextServer) Call0Context(_ context.Context, id any, args ...any) error {
+	return s.Call0(id, args...)
+}
+
+func (s noContextServer) Call1Context(_ context.Context, id any, args ...any) (any, error) {
+	return s.Call1(id, args...)
+}
+
+func (s noContextServer) CallNContext(_ context.Context, id any, args ...any) ([]any, error) {
+	return s.CallN(id, args...)
+}
+
+const defaultMaxConcurrentCalls = 64
+
+// Run 阻塞运行，ctx结束后关闭；配置错误或者监听失败时返回错误
+func (s *Server) Run(ctx context.Context) error {
+	if s.Addr == "" {
+		return errors.New("chanrpc server addr not set")
+	}
+	if s.RPCServer == nil {
+		return errors.New("chanrpc server RPCServer not set")
+	}
+	if s.MaxConcurrentCalls <= 0 {
+		s.MaxConcurrentCalls = defaultMaxConcurrentCalls
+	}
+	if s.MsgProcessor == nil {
+		s.MsgProcessor = NewJsonProcessor()
+	}
+	if cs, ok := s.RPCServer.(ContextServer); ok {
+		s.callServer = cs
+	} else {
+		s.callServer = noContextServer{s.RPCServer}
+	}
+	tcpServer := new(tcp.Server)
+	tcpServer.Addr = s.Addr
+	tcpServer.MaxConnNum = s.MaxConnNum
+	tcpServer.Parser = s.BinaryParser
+	tcpServer.NewSessionFunc = func(conn *tcp.Conn) network.Session {
+		sctx, cancel := context.WithCancel(ctx)
+		return &serverSession{conn: conn, s: s, sem: make(chan struct{}, s.MaxConcurrentCalls),
+			ctx: sctx, cancel: cancel}
+	}
+
+	if err := tcpServer.Listen(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	tcpServer.Close()
+	return nil
+}
+
+type serverSession struct {
+	conn network.Conn
+	s    *Server
+	sem  chan struct{} //限制同时执行的同步调用数
+	//连接关闭或者服务端停止时取消，正在等待的调用随之结束
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (ss *serverSession) Run() {
+	for {
+		data, err := ss.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message error: %v", err)
+			break
+		}
+		if len(data) == 0 {
+			continue
+		}
+		msg, err := ss.s.MsgProcessor.Unmarshal(data)
+		if err != nil {
+			log.Debug("unmarshal message error: %v", err)
+			break
+		}
+		req, ok := msg.(*CallRequest)
+		if !ok {
+			log.Warn("unexpected chanrpc message %T from %v", msg, ss.conn.RemoteAddr())
+			continue
+		}
+		if req.Type == CallGo {
+			ss.s.RPCServer.Go(req.Id, req.Args...)
+			continue
+		}
+		//同步调用会阻塞到模块处理完毕，不能阻塞读循环；并发数满了之后等待，相当于对这个连接限流
+		ss.sem <- struct{}{}
+		go func() {
+			defer func() { <-ss.sem }()
+			ss.call(req)
+		}()
+	}
+}
+
+// callTimeout 服务端配置和请求中的超时时间取较小的
+func (ss *serverSession) callTimeout(req *CallRequest) time.Duration {
+	timeout := ss.s.CallTimeout
+	if req.Timeout > 0 {
+		if d := time.Duration(req.Timeout) * time.Millisecond; timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	return timeout
+}
+
+func (ss *serverSession) call(req *CallRequest) {
+	resp := &CallResponse{Seq: req.Seq}
+	var err error
+	ctx := ss.ctx
+	if timeout := ss.callTimeout(req); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	switch req.Type {
+	case Call0:
+		err = ss.s.callServer.Call0Context(ctx, req.Id, req.Args...)
+	case Call1:
+		var ret any
+		ret, err = ss.s.callServer.Call1Context(ctx, req.Id, req.Args...)
+		resp.Ret = []any{ret}
+	case CallN:
+		resp.Ret, err = ss.s.callServer.CallNContext(ctx, req.Id, req.Args...)
+	default:
+		resp.Err = "unknown call type"
+	}
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	//连接已经关闭，结果没有人接收
+	if ss.ctx.Err() != nil {
+		return
+	}
+	if err = writeMsg(ss.conn, ss.s.MsgProcessor, resp); err != nil {
+		log.Error("write chanrpc response %s error: %v", req.Id, err)
+	}
+}
+
+func (ss *serverSession) OnClose() {
+	ss.cancel()
+}
@@ -1,10 +1,13 @@
 package gate
 
 import (
+	"crypto/x509"
 	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/network"
 	"net"
 	"reflect"
+	"sync/atomic"
+	"time"
 )
 
 //Agent 是对各种网络协议连接的抽象
@@ -24,6 +27,21 @@ type SessionAgentImpl struct {
 	Conn network.Conn
 	Gate IGate
 	Data any
+	// IdleTimeout 超过这个时间没有收到消息就断开连接，0表示不检查，需要Conn实现network.TimeoutConn
+	IdleTimeout time.Duration
+	// WriteTimeout 写入超时，0表示不限制，需要Conn实现network.TimeoutConn
+	WriteTimeout time.Duration
+	// Heartbeat 应用层心跳，可以为nil
+	Heartbeat *Heartbeat
+	// ReleaseMsg Unmarshal之后不再引用原始数据时可以设为true，读取的缓冲区会归还给连接复用
+	// 需要Conn实现network.MsgReleaser
+	ReleaseMsg bool
+	// Stats 统计反序列化错误，可以为nil
+	Stats *network.Stats
+
+	closedByLocal int32
+	closeReason   CloseReason
+	closeErr      error
 }
 
 // CheckAuth 一般的用来校验是否验证通过的函数
@@ -41,20 +59,46 @@ func CheckAuth(ag Agent) bool {
 // Run session数据的处理循环
 //这里出现真的错误才要断开连接
 func (a *SessionAgentImpl) Run() {
+	if tc, ok := a.Conn.(network.TimeoutConn); ok {
+		if a.IdleTimeout > 0 {
+			tc.SetReadTimeout(a.IdleTimeout)
+		}
+		if a.WriteTimeout > 0 {
+			tc.SetWriteTimeout(a.WriteTimeout)
+		}
+	}
+	if hb := a.Heartbeat; hb != nil && hb.Interval > 0 && hb.Ping != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go a.keepAlive(done)
+	}
+	a.closeReason, a.closeErr = a.loop()
+	if atomic.LoadInt32(&a.closedByLocal) == 1 {
+		a.closeReason = CloseByLocal
+	}
+}
+
+func (a *SessionAgentImpl) loop() (CloseReason, error) {
 	for {
 		data, err := a.Conn.ReadMsg()
 		if err != nil {
 			log.Debug("read message error: %v", err)
-			break
+			return readCloseReason(err), err
 		}
 		if len(data) == 0 {
 			continue
 		}
+		if a.isPing(data) {
+			a.release(data)
+			continue
+		}
 		if a.Gate.Processor() != nil {
 			msg, err := a.Gate.Processor().Unmarshal(data)
+			a.release(data)
 			if err != nil {
+				a.Stats.ParseError()
 				log.Debug("unmarshal message error: %v", err)
-				break
+				return CloseUnmarshalError, err
 			}
 			if msg == nil {
 				continue
@@ -62,7 +106,50 @@ func (a *SessionAgentImpl) Run() {
 			err = a.Gate.Processor().Route(msg, a)
 			if err != nil {
 				log.Debug("route message error: %v", err)
-				break
+				return CloseRouteError, err
+			}
+		}
+	}
+}
+
+func (a *SessionAgentImpl) release(data []byte) {
+	if !a.ReleaseMsg {
+		return
+	}
+	if r, ok := a.Conn.(network.MsgReleaser); ok {
+		r.ReleaseMsg(data)
+	}
+}
+
+// isPing 是心跳时按需回复
+func (a *SessionAgentImpl) isPing(data []byte) bool {
+	hb := a.Heartbeat
+	if hb == nil || hb.IsPing == nil || !hb.IsPing(data) {
+		return false
+	}
+	if hb.Pong != nil {
+		if pong := hb.Pong(data); pong != nil {
+			if err := a.Conn.WriteMsg(pong); err != nil {
+				log.Warn("write pong to %v error: %v", a.RemoteAddr(), err)
+			}
+		}
+	}
+	return true
+}
+
+// keepAlive 定时发送心跳，直到Run退出
+func (a *SessionAgentImpl) keepAlive(done chan struct{}) {
+	ticker := time.NewTicker(a.Heartbeat.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if ping := a.Heartbeat.Ping(); ping != nil {
+				if err := a.Conn.WriteMsg(ping); err != nil {
+					log.Warn("write ping to %v error: %v", a.RemoteAddr(), err)
+				}
 			}
 		}
 	}
@@ -74,9 +161,20 @@ func (a *SessionAgentImpl) OnClose() {
 		if err != nil {
 			log.Warn("chanrpc error: %v", err)
 		}
+		a.Gate.AgentChanRPC().Go(AgentClosedEvent, a, a.closeReason, a.closeErr)
 	}
 }
 
+// CloseReason 连接关闭的原因和导致关闭的错误，只在Run返回之后有效
+func (a *SessionAgentImpl) CloseReason() (CloseReason, error) {
+	return a.closeReason, a.closeErr
+}
+
+// CloseReasonText 实现network.ReasonedSession，用于统计
+func (a *SessionAgentImpl) CloseReasonText() string {
+	return a.closeReason.String()
+}
+
 func (a *SessionAgentImpl) WriteMsg(msg any) {
 	if a.Gate.Processor() != nil {
 		data, err := a.Gate.Processor().Marshal(msg)
@@ -99,11 +197,21 @@ func (a *SessionAgentImpl) RemoteAddr() net.Addr {
 	return a.Conn.RemoteAddr()
 }
 
+// PeerCertificates 对端的tls证书链，连接不是tls时为空
+func (a *SessionAgentImpl) PeerCertificates() []*x509.Certificate {
+	if tc, ok := a.Conn.(network.TLSConn); ok {
+		return tc.PeerCertificates()
+	}
+	return nil
+}
+
 func (a *SessionAgentImpl) Close() {
+	atomic.StoreInt32(&a.closedByLocal, 1)
 	a.Conn.Close()
 }
 
 func (a *SessionAgentImpl) Destroy() {
+	atomic.StoreInt32(&a.closedByLocal, 1)
 	a.Conn.Destroy()
 }
 
@@ -0,0 +1,186 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/go-common/base/structs/rpc"
+	"github.com/YiuTerran/go-common/network"
+	"github.com/YiuTerran/go-common/network/tcp"
+)
+
+// event 发给AgentChanRPC的异步通知
+type event struct {
+	id   any
+	args []any
+}
+
+// eventServer 记录Go调用的rpc.IServer
+type eventServer struct {
+	events chan event
+}
+
+func (s *eventServer) Go(id any, args ...any)                   { s.events <- event{id: id, args: args} }
+func (s *eventServer) Call0(id any, args ...any) error          { return nil }
+func (s *eventServer) Call1(id any, args ...any) (any, error)   { return nil, nil }
+func (s *eventServer) CallN(id any, args ...any) ([]any, error) { return nil, nil }
+
+type testGate struct {
+	rpc *eventServer
+}
+
+func (g *testGate) Processor() network.MsgProcessor { return nil }
+func (g *testGate) AgentChanRPC() rpc.IServer       { return g.rpc }
+
+// startAgent 启动tcp服务，每个连接使用init配置的SessionAgentImpl，返回客户端连接
+func startAgent(t *testing.T, init func(a *SessionAgentImpl)) (net.Conn, *eventServer) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	g := &testGate{rpc: &eventServer{events: make(chan event, 8)}}
+	server := &tcp.Server{Addr: addr, NewSessionFunc: func(conn *tcp.Conn) network.Session {
+		a := &SessionAgentImpl{Conn: conn, Gate: g}
+		init(a)
+		return a
+	}}
+	if err = server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, g.rpc
+}
+
+func writeFrame(t *testing.T, conn net.Conn, data string) {
+	frame := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(frame, uint16(len(data)))
+	copy(frame[2:], data)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFrame(t *testing.T, conn net.Conn) string {
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// waitClosed 等待AgentClosedEvent，返回关闭原因
+func waitClosed(t *testing.T, s *eventServer) CloseReason {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case e := <-s.events:
+			if e.id == AgentClosedEvent {
+				if a, ok := e.args[0].(*SessionAgentImpl); !ok || a == nil {
+					t.Fatalf("unexpected agent %v", e.args[0])
+				}
+				return e.args[1].(CloseReason)
+			}
+		case <-timeout:
+			t.Fatal("AgentClosedEvent not delivered")
+		}
+	}
+}
+
+func TestSessionAgent_IdleTimeout(t *testing.T) {
+	conn, events := startAgent(t, func(a *SessionAgentImpl) {
+		a.IdleTimeout = 100 * time.Millisecond
+	})
+	start := time.Now()
+	if reason := waitClosed(t, events); reason != CloseIdleTimeout {
+		t.Fatalf("expect idle timeout, got %v", reason)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("closed too early: %v", elapsed)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect EOF after idle timeout, got %v", err)
+	}
+}
+
+func TestSessionAgent_Heartbeat(t *testing.T) {
+	conn, events := startAgent(t, func(a *SessionAgentImpl) {
+		a.IdleTimeout = 150 * time.Millisecond
+		a.Heartbeat = &Heartbeat{
+			IsPing: func(data []byte) bool { return bytes.Equal(data, []byte("ping")) },
+			Pong:   func([]byte) []byte { return []byte("pong") },
+		}
+	})
+	//心跳会刷新空闲时间，总时长超过IdleTimeout也不会断开
+	for i := 0; i < 3; i++ {
+		time.Sleep(80 * time.Millisecond)
+		writeFrame(t, conn, "ping")
+		if pong := readFrame(t, conn); pong != "pong" {
+			t.Fatalf("expect pong, got %q", pong)
+		}
+	}
+	_ = conn.Close()
+	if reason := waitClosed(t, events); reason != CloseByPeer {
+		t.Fatalf("expect closed by peer, got %v", reason)
+	}
+}
+
+func TestSessionAgent_EchoPong(t *testing.T) {
+	conn, _ := startAgent(t, func(a *SessionAgentImpl) {
+		a.ReleaseMsg = true
+		a.Heartbeat = &Heartbeat{
+			IsPing: func(data []byte) bool { return bytes.HasPrefix(data, []byte("ping")) },
+			Pong:   func(ping []byte) []byte { return ping },
+		}
+	})
+	//回显的pong引用读取的缓冲区，归还之后被后面的ping复用，不复制的话回复的内容会错乱
+	var buf bytes.Buffer
+	for i := 0; i < 100; i++ {
+		data := fmt.Sprintf("ping-%03d", i)
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(data)))
+		buf.WriteString(data)
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if pong, expect := readFrame(t, conn), fmt.Sprintf("ping-%03d", i); pong != expect {
+			t.Fatalf("expect %s, got %s", expect, pong)
+		}
+	}
+}
+
+func TestSessionAgent_ActivePing(t *testing.T) {
+	conn, events := startAgent(t, func(a *SessionAgentImpl) {
+		a.Heartbeat = &Heartbeat{
+			Interval: 20 * time.Millisecond,
+			Ping:     func() []byte { return []byte("hb") },
+		}
+		time.AfterFunc(200*time.Millisecond, a.Close)
+	})
+	for i := 0; i < 2; i++ {
+		if ping := readFrame(t, conn); ping != "hb" {
+			t.Fatalf("expect hb, got %q", ping)
+		}
+	}
+	if reason := waitClosed(t, events); reason != CloseByLocal {
+		t.Fatalf("expect closed by local, got %v", reason)
+	}
+}
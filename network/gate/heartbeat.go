@@ -0,0 +1,74 @@
+package gate
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+/**  会话的超时、应用层心跳和关闭原因
+  *  IdleTimeout内没有收到任何消息(包括心跳)就断开连接，心跳报文由Heartbeat识别和回复，不会交给MsgProcessor
+  *  连接关闭之后通过AgentClosedEvent通知关闭原因
+**/
+
+// Heartbeat 应用层心跳策略，报文都是分包之后(tcp经过IParser)的数据
+type Heartbeat struct {
+	// IsPing 判断收到的报文是否是心跳，为nil时所有报文都交给MsgProcessor
+	IsPing func(data []byte) bool
+	// Pong 收到心跳之后回复的报文，为nil或者返回nil时不回复
+	// 可以直接返回ping(回显)，写入时会复制
+	Pong func(ping []byte) []byte
+	// Interval 主动发送心跳的间隔，0表示不主动发送，一般只在客户端设置
+	Interval time.Duration
+	// Ping 主动发送的心跳报文
+	Ping func() []byte
+}
+
+// CloseReason 连接关闭的原因
+type CloseReason int
+
+const (
+	// CloseByPeer 对端关闭连接
+	CloseByPeer CloseReason = iota
+	// CloseByLocal 本端调用了Agent的Close或者Destroy
+	CloseByLocal
+	// CloseIdleTimeout 超过IdleTimeout没有收到消息
+	CloseIdleTimeout
+	// CloseReadError 读取或者写入出错
+	CloseReadError
+	// CloseUnmarshalError 反序列化消息出错
+	CloseUnmarshalError
+	// CloseRouteError 路由消息出错
+	CloseRouteError
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseByPeer:
+		return "closed by peer"
+	case CloseByLocal:
+		return "closed by local"
+	case CloseIdleTimeout:
+		return "idle timeout"
+	case CloseReadError:
+		return "read error"
+	case CloseUnmarshalError:
+		return "unmarshal error"
+	case CloseRouteError:
+		return "route error"
+	}
+	return "unknown"
+}
+
+// readCloseReason 根据ReadMsg返回的错误判断关闭原因
+func readCloseReason(err error) CloseReason {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return CloseIdleTimeout
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return CloseByPeer
+	}
+	return CloseReadError
+}
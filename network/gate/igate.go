@@ -8,6 +8,8 @@ import (
 const (
 	AgentCreatedEvent     = "NewSessionFunc"
 	AgentBeforeCloseEvent = "CloseAgent"
+	// AgentClosedEvent 连接关闭之后异步通知，参数是agent、CloseReason和导致关闭的error(可能为nil)
+	AgentClosedEvent = "AgentClosed"
 )
 
 // IGate 路由
@@ -5,6 +5,7 @@ import (
 	"github.com/YiuTerran/go-common/base/structs/rpc"
 	"github.com/YiuTerran/go-common/network"
 	"github.com/YiuTerran/go-common/network/tcp"
+	"time"
 )
 
 type TcpClient struct {
@@ -14,6 +15,11 @@ type TcpClient struct {
 	BinaryParser  tcp.IParser
 	AutoReconnect bool
 	UserData      any
+	TLS           *tcp.TLSOptions
+	IdleTimeout   time.Duration
+	WriteTimeout  time.Duration
+	Heartbeat     *Heartbeat
+	ReleaseMsg    bool
 }
 
 func (c *TcpClient) Processor() network.MsgProcessor {
@@ -31,8 +37,11 @@ func (c *TcpClient) Run(ctx context.Context) {
 			Addr:          c.Server,
 			AutoReconnect: c.AutoReconnect,
 			Parser:        c.BinaryParser,
+			TLS:           c.TLS,
 			NewAgentFunc: func(conn *tcp.Conn) network.Session {
-				a := &SessionAgentImpl{Conn: conn, Gate: c}
+				a := &SessionAgentImpl{Conn: conn, Gate: c,
+					IdleTimeout: c.IdleTimeout, WriteTimeout: c.WriteTimeout, Heartbeat: c.Heartbeat,
+					ReleaseMsg: c.ReleaseMsg}
 				if c.RPCServer != nil {
 					c.RPCServer.Go(AgentCreatedEvent, a, c.UserData)
 				}
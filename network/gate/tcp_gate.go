@@ -6,6 +6,7 @@ import (
 	"github.com/YiuTerran/go-common/base/structs/rpc"
 	"github.com/YiuTerran/go-common/network"
 	"github.com/YiuTerran/go-common/network/tcp"
+	"time"
 )
 
 // TcpGate 一个封装后的TCP服务
@@ -20,6 +21,18 @@ type TcpGate struct {
 	RPCServer rpc.IServer
 	//二进制分包
 	BinaryParser tcp.IParser
+	//不为nil时使用tls，对端证书可以通过SessionAgentImpl.PeerCertificates获取
+	TLS *tcp.TLSOptions
+	//超过这个时间没有收到消息就断开连接，0表示不检查
+	IdleTimeout time.Duration
+	//写入超时，0表示不限制
+	WriteTimeout time.Duration
+	//应用层心跳，可以为nil
+	Heartbeat *Heartbeat
+	//MsgProcessor.Unmarshal不引用原始数据时可以设为true，复用读取的缓冲区
+	ReleaseMsg bool
+	//不为nil时统计连接数、流量等，可以用prom.RegisterNetwork导出
+	Stats *network.Stats
 }
 
 func (gate *TcpGate) Processor() network.MsgProcessor {
@@ -39,8 +52,12 @@ func (gate *TcpGate) Run(ctx context.Context) {
 	tcpServer.Addr = gate.Addr
 	tcpServer.MaxConnNum = gate.MaxConnNum
 	tcpServer.Parser = gate.BinaryParser
+	tcpServer.TLS = gate.TLS
+	tcpServer.Stats = gate.Stats
 	tcpServer.NewSessionFunc = func(conn *tcp.Conn) network.Session {
-		a := &SessionAgentImpl{Conn: conn, Gate: gate}
+		a := &SessionAgentImpl{Conn: conn, Gate: gate,
+			IdleTimeout: gate.IdleTimeout, WriteTimeout: gate.WriteTimeout, Heartbeat: gate.Heartbeat,
+			ReleaseMsg: gate.ReleaseMsg, Stats: gate.Stats}
 		if gate.RPCServer != nil {
 			gate.RPCServer.Go(AgentCreatedEvent, a)
 		}
@@ -1,6 +1,10 @@
 package network
 
-import "net"
+import (
+	"crypto/x509"
+	"net"
+	"time"
+)
 
 // Session 每个连接在独立的协程里处理消息
 type Session interface {
@@ -20,6 +24,25 @@ type Conn interface {
 	Destroy()
 }
 
+// TLSConn 可选接口，tls连接可以获取对端的证书链，用于设备认证等
+// 不是tls连接或者对端没有出示证书时为空
+type TLSConn interface {
+	PeerCertificates() []*x509.Certificate
+}
+
+// TimeoutConn 可选接口，支持读写超时的连接，goroutine safe
+type TimeoutConn interface {
+	// SetReadTimeout 每次ReadMsg等待消息的超时，超时之后ReadMsg返回net.Error(Timeout()为true)，0表示不超时
+	SetReadTimeout(d time.Duration)
+	// SetWriteTimeout 每次写入连接的超时，写入超时之后连接会被关闭，0表示不超时
+	SetWriteTimeout(d time.Duration)
+}
+
+// MsgReleaser 可选接口，ReadMsg返回的消息处理完之后可以归还缓冲区复用
+type MsgReleaser interface {
+	ReleaseMsg(msg []byte)
+}
+
 // MsgProcessor 是消息处理器
 type MsgProcessor interface {
 	// Route 路由消息 must goroutine safe
@@ -10,11 +10,14 @@ import (
 	"fmt"
 	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/base/structs/rpc"
+	"github.com/YiuTerran/go-common/network"
 	"reflect"
+	"time"
 )
 
 type JsonProcessor struct {
 	msgInfo map[string]*MsgInfo
+	stats   *network.Stats
 }
 
 type MsgInfo struct {
@@ -37,6 +40,12 @@ func NewProcessor() *JsonProcessor {
 	return p
 }
 
+// SetStats 按消息id统计同步的handler(SetHandler、SetRawHandler)的耗时，需要在使用之前调用
+// 投递给msgRouter的消息是异步处理的，Route无法知道什么时候处理完，不统计；需要时在模块的处理函数中调用Stats.HandlerDone
+func (p *JsonProcessor) SetStats(stats *network.Stats) {
+	p.stats = stats
+}
+
 // Register 注册消息
 //It's dangerous to call the method on routing or
 //marshaling/unmarshalling
@@ -110,7 +119,9 @@ func (p *JsonProcessor) Route(msg any, userData any) error {
 			return fmt.Errorf("message %v not registered", msgRaw.msgID)
 		}
 		if i.msgRawHandler != nil {
+			start := time.Now()
 			i.msgRawHandler([]any{msgRaw.msgID, msgRaw.msgRawData, userData})
+			p.stats.HandlerDone(msgRaw.msgID, time.Since(start))
 		}
 		return nil
 	}
@@ -126,7 +137,9 @@ func (p *JsonProcessor) Route(msg any, userData any) error {
 		return fmt.Errorf("message %v not registered", msgID)
 	}
 	if i.msgHandler != nil {
+		start := time.Now()
 		i.msgHandler([]any{msg, userData})
+		p.stats.HandlerDone(msgID, time.Since(start))
 	}
 	if i.msgRouter != nil {
 		i.msgRouter.Go(msgType, msg, userData)
@@ -0,0 +1,63 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/YiuTerran/go-common/network"
+)
+
+type Login struct {
+	Name string
+}
+
+type Logout struct{}
+
+// router 记录Go调用的rpc.IServer
+type router struct {
+	ids []any
+}
+
+func (r *router) Go(id any, args ...any)                   { r.ids = append(r.ids, id) }
+func (r *router) Call0(id any, args ...any) error          { return nil }
+func (r *router) Call1(id any, args ...any) (any, error)   { return nil, nil }
+func (r *router) CallN(id any, args ...any) ([]any, error) { return nil, nil }
+
+func TestJsonProcessor_Route(t *testing.T) {
+	p := NewProcessor()
+	stats := network.NewStats()
+	p.SetStats(stats)
+	p.Register(&Login{})
+	p.Register(&Logout{})
+	var handled string
+	p.SetHandler(&Login{}, func(args []any) { handled = args[0].(*Login).Name })
+	r := &router{}
+	p.SetRouter(&Logout{}, r)
+
+	data, err := p.Marshal(&Login{Name: "tom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := p.Unmarshal(data[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Route(msg, nil); err != nil || handled != "tom" {
+		t.Fatalf("login not handled: %v", err)
+	}
+	if err = p.Route(&Logout{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.ids) != 1 || r.ids[0] != reflect.TypeOf(&Logout{}) {
+		t.Fatalf("logout not routed: %v", r.ids)
+	}
+
+	//只统计同步的handler，投递给msgRouter的不统计
+	counts := make(map[string]uint64)
+	stats.ForEachHandler(func(id string, count uint64, sum float64, buckets map[float64]uint64) {
+		counts[id] = count
+	})
+	if len(counts) != 1 || counts["Login"] != 1 {
+		t.Fatalf("unexpected handler counts %v", counts)
+	}
+}
@@ -0,0 +1,260 @@
+package network
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**  连接级别的统计，tcp/udp/ws的Server设置Stats之后自动记录
+  *  可以用prom.RegisterNetwork导出给prometheus
+**/
+
+// DefaultLatencyBuckets 消息处理耗时的分桶，单位秒
+var DefaultLatencyBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// QueuedConn 可选接口，连接的写队列长度
+type QueuedConn interface {
+	WriteQueueLen() int
+}
+
+// ReasonedSession 可选接口，Run返回之后说明连接关闭的原因，用于统计
+// gate.SessionAgentImpl实现了这个接口
+type ReasonedSession interface {
+	Session
+	CloseReasonText() string
+}
+
+// UnknownCloseReason Session没有说明关闭原因时使用
+const UnknownCloseReason = "unknown"
+
+// Stats 一个服务的统计，goroutine safe，nil表示不统计
+type Stats struct {
+	accepted    int64
+	rejected    int64
+	active      int64
+	bytesIn     int64
+	bytesOut    int64
+	msgsIn      int64
+	msgsOut     int64
+	parseErrors int64
+
+	mu       sync.Mutex
+	closed   map[string]int64
+	conns    map[QueuedConn]struct{}
+	handlers map[string]*latency
+	buckets  []float64
+}
+
+type latency struct {
+	count  uint64
+	sum    float64
+	counts []uint64 //每个桶(不累计)
+}
+
+// NewStats buckets是消息处理耗时的分桶(秒，升序)，为空时使用DefaultLatencyBuckets
+func NewStats(buckets ...float64) *Stats {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Stats{
+		closed:   make(map[string]int64),
+		conns:    make(map[QueuedConn]struct{}),
+		handlers: make(map[string]*latency),
+		buckets:  buckets,
+	}
+}
+
+// ConnAccepted 接受了新连接，conn实现QueuedConn时会统计写队列长度
+func (s *Stats) ConnAccepted(conn any) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.accepted, 1)
+	atomic.AddInt64(&s.active, 1)
+	if qc, ok := conn.(QueuedConn); ok {
+		s.mu.Lock()
+		s.conns[qc] = struct{}{}
+		s.mu.Unlock()
+	}
+}
+
+// ConnRejected 连接被拒绝(超过最大连接数、握手失败、认证失败等)
+func (s *Stats) ConnRejected() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.rejected, 1)
+}
+
+// ConnClosed ConnAccepted的连接关闭了
+func (s *Stats) ConnClosed(conn any, reason string) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.active, -1)
+	s.mu.Lock()
+	s.closed[reason]++
+	if qc, ok := conn.(QueuedConn); ok {
+		delete(s.conns, qc)
+	}
+	s.mu.Unlock()
+}
+
+// SessionClosed 同ConnClosed，关闭原因由session提供
+func (s *Stats) SessionClosed(conn any, session Session) {
+	if s == nil {
+		return
+	}
+	reason := UnknownCloseReason
+	if rs, ok := session.(ReasonedSession); ok {
+		reason = rs.CloseReasonText()
+	}
+	s.ConnClosed(conn, reason)
+}
+
+// BytesIn 收到了n字节
+func (s *Stats) BytesIn(n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&s.bytesIn, int64(n))
+}
+
+// BytesOut 发送了n字节
+func (s *Stats) BytesOut(n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&s.bytesOut, int64(n))
+}
+
+// MsgIn 收到了一个完整的消息
+func (s *Stats) MsgIn() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.msgsIn, 1)
+}
+
+// MsgOut 发送了一个消息
+func (s *Stats) MsgOut() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.msgsOut, 1)
+}
+
+// ParseError 分包或者反序列化出错
+func (s *Stats) ParseError() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.parseErrors, 1)
+}
+
+// ReadError 根据ReadMsg的错误判断是不是分包出错，连接本身的错误(超时、关闭等)不算
+func (s *Stats) ReadError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) {
+		return
+	}
+	s.ParseError()
+}
+
+// HandlerDone 消息处理完毕，id是消息id
+func (s *Stats) HandlerDone(id string, d time.Duration) {
+	if s == nil {
+		return
+	}
+	sec := d.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.handlers[id]
+	if !ok {
+		l = &latency{counts: make([]uint64, len(s.buckets))}
+		s.handlers[id] = l
+	}
+	l.count++
+	l.sum += sec
+	if i := sort.SearchFloat64s(s.buckets, sec); i < len(s.buckets) {
+		l.counts[i]++
+	}
+}
+
+// ConnCounts 接受、拒绝的连接总数和当前连接数
+func (s *Stats) ConnCounts() (accepted, rejected, active int64) {
+	return atomic.LoadInt64(&s.accepted), atomic.LoadInt64(&s.rejected), atomic.LoadInt64(&s.active)
+}
+
+// ClosedCounts 关闭原因 -> 关闭的连接数
+func (s *Stats) ClosedCounts() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := make(map[string]int64, len(s.closed))
+	for k, v := range s.closed {
+		resp[k] = v
+	}
+	return resp
+}
+
+// Traffic 收发的字节数和消息数
+func (s *Stats) Traffic() (bytesIn, bytesOut, msgsIn, msgsOut int64) {
+	return atomic.LoadInt64(&s.bytesIn), atomic.LoadInt64(&s.bytesOut),
+		atomic.LoadInt64(&s.msgsIn), atomic.LoadInt64(&s.msgsOut)
+}
+
+// ParseErrors 分包或者反序列化出错的次数
+func (s *Stats) ParseErrors() int64 {
+	return atomic.LoadInt64(&s.parseErrors)
+}
+
+// WriteQueueSizes 当前每个连接的写队列长度
+func (s *Stats) WriteQueueSizes() []int {
+	s.mu.Lock()
+	conns := make([]QueuedConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	resp := make([]int, len(conns))
+	for i, c := range conns {
+		resp[i] = c.WriteQueueLen()
+	}
+	return resp
+}
+
+// ForEachHandler 遍历每个消息id的处理耗时，buckets是上限 -> 累计数量，和prometheus的histogram一致
+func (s *Stats) ForEachHandler(f func(id string, count uint64, sum float64, buckets map[float64]uint64)) {
+	s.mu.Lock()
+	type snapshot struct {
+		id      string
+		count   uint64
+		sum     float64
+		buckets map[float64]uint64
+	}
+	snapshots := make([]snapshot, 0, len(s.handlers))
+	for id, l := range s.handlers {
+		buckets := make(map[float64]uint64, len(s.buckets))
+		var cumulative uint64
+		for i, upper := range s.buckets {
+			cumulative += l.counts[i]
+			buckets[upper] = cumulative
+		}
+		snapshots = append(snapshots, snapshot{id: id, count: l.count, sum: l.sum, buckets: buckets})
+	}
+	s.mu.Unlock()
+	for _, ss := range snapshots {
+		f(ss.id, ss.count, ss.sum, ss.buckets)
+	}
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+type queuedConn struct {
+	n int
+}
+
+func (c *queuedConn) WriteQueueLen() int { return c.n }
+
+type reasonedSession struct{}
+
+func (reasonedSession) Run()                    {}
+func (reasonedSession) OnClose()                {}
+func (reasonedSession) CloseReasonText() string { return "idle timeout" }
+
+type plainSession struct{}
+
+func (plainSession) Run()     {}
+func (plainSession) OnClose() {}
+
+func TestStats_ReadError(t *testing.T) {
+	s := NewStats()
+	//连接本身的错误不算分包错误
+	for _, err := range []error{
+		nil, io.EOF, io.ErrUnexpectedEOF, net.ErrClosed, os.ErrDeadlineExceeded,
+		fmt.Errorf("read: %w", io.EOF),
+		&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")},
+	} {
+		s.ReadError(err)
+		if n := s.ParseErrors(); n != 0 {
+			t.Fatalf("%v should not be a parse error", err)
+		}
+	}
+	s.ReadError(errors.New("message too long"))
+	s.ReadError(fmt.Errorf("invalid escape sequence at %d", 1))
+	if n := s.ParseErrors(); n != 2 {
+		t.Fatalf("expect 2 parse errors, got %d", n)
+	}
+
+	//nil表示不统计
+	var ns *Stats
+	ns.ReadError(errors.New("message too long"))
+	ns.HandlerDone("a", time.Second)
+	ns.ConnAccepted(nil)
+	ns.SessionClosed(nil, plainSession{})
+}
+
+func TestStats_Conns(t *testing.T) {
+	s := NewStats()
+	c1, c2 := &queuedConn{n: 3}, &queuedConn{n: 5}
+	s.ConnAccepted(c1)
+	s.ConnAccepted(c2)
+	s.ConnAccepted(nil)
+	s.ConnRejected()
+	if sizes := s.WriteQueueSizes(); len(sizes) != 2 || sizes[0]+sizes[1] != 8 {
+		t.Fatalf("unexpected write queue sizes %v", sizes)
+	}
+	s.SessionClosed(c1, reasonedSession{})
+	s.SessionClosed(nil, plainSession{})
+	if accepted, rejected, active := s.ConnCounts(); accepted != 3 || rejected != 1 || active != 1 {
+		t.Fatalf("unexpected conn counts %d %d %d", accepted, rejected, active)
+	}
+	closed := s.ClosedCounts()
+	if len(closed) != 2 || closed["idle timeout"] != 1 || closed[UnknownCloseReason] != 1 {
+		t.Fatalf("unexpected closed counts %v", closed)
+	}
+	if sizes := s.WriteQueueSizes(); len(sizes) != 1 || sizes[0] != 5 {
+		t.Fatalf("unexpected write queue sizes %v", sizes)
+	}
+
+	s.BytesIn(10)
+	s.BytesIn(-1)
+	s.BytesOut(20)
+	s.MsgIn()
+	s.MsgOut()
+	s.MsgOut()
+	if bytesIn, bytesOut, msgsIn, msgsOut := s.Traffic(); bytesIn != 10 || bytesOut != 20 || msgsIn != 1 || msgsOut != 2 {
+		t.Fatalf("unexpected traffic %d %d %d %d", bytesIn, bytesOut, msgsIn, msgsOut)
+	}
+}
+
+func TestStats_HandlerBuckets(t *testing.T) {
+	//分桶会被排序
+	s := NewStats(0.1, 0.001, 0.01)
+	for _, d := range []time.Duration{
+		time.Millisecond, //等于上限的计入这个桶
+		5 * time.Millisecond,
+		50 * time.Millisecond,
+		time.Second, //超过所有上限的只计入总数
+	} {
+		s.HandlerDone("login", d)
+	}
+	s.HandlerDone("logout", 500*time.Microsecond)
+
+	result := make(map[string]map[float64]uint64)
+	s.ForEachHandler(func(id string, count uint64, sum float64, buckets map[float64]uint64) {
+		result[id] = buckets
+		switch id {
+		case "login":
+			if count != 4 || sum < 1.0559 || sum > 1.0561 {
+				t.Fatalf("unexpected login count %d sum %f", count, sum)
+			}
+		case "logout":
+			if count != 1 {
+				t.Fatalf("unexpected logout count %d", count)
+			}
+		}
+	})
+	expect := map[string]map[float64]uint64{
+		"login":  {0.001: 1, 0.01: 2, 0.1: 3},
+		"logout": {0.001: 1, 0.01: 1, 0.1: 1},
+	}
+	if fmt.Sprint(result) != fmt.Sprint(expect) {
+		t.Fatalf("expect %v, got %v", expect, result)
+	}
+}
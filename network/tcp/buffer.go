@@ -0,0 +1,62 @@
+package tcp
+
+import (
+	"math/bits"
+	"sync"
+)
+
+/**  消息缓冲区池，按2的幂分级，避免每个消息都分配内存
+  *  parser读取的消息来自这里，处理完之后可以调用Conn.ReleaseMsg归还；不归还也没关系，只是交给GC回收
+**/
+
+const (
+	minBufferShift = 6  //64B
+	maxBufferShift = 16 //64K，更大的缓冲区不复用
+)
+
+var (
+	bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+	//放入Pool的*[]byte也复用，避免每次归还都分配
+	holderPool sync.Pool
+)
+
+func bufferClass(n int) int {
+	if n <= 1<<minBufferShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minBufferShift
+}
+
+// GetBuffer 获取长度为n的缓冲区，内容是未初始化的
+func GetBuffer(n int) []byte {
+	class := bufferClass(n)
+	if class >= len(bufferPools) {
+		return make([]byte, n)
+	}
+	if bp, ok := bufferPools[class].Get().(*[]byte); ok {
+		b := (*bp)[:n]
+		*bp = nil
+		holderPool.Put(bp)
+		return b
+	}
+	return make([]byte, n, 1<<(class+minBufferShift))
+}
+
+// PutBuffer 归还GetBuffer获取的缓冲区，之后不能再使用b(包括b的子切片)
+// 只能归还GetBuffer获取的缓冲区，容量不符合分级的切片会被忽略
+func PutBuffer(b []byte) {
+	c := cap(b)
+	if c < 1<<minBufferShift || c&(c-1) != 0 {
+		return
+	}
+	class := bufferClass(c)
+	if class >= len(bufferPools) {
+		return
+	}
+	bp, ok := holderPool.Get().(*[]byte)
+	if !ok {
+		bp = new([]byte)
+	}
+	*bp = b[:0]
+	bufferPools[class].Put(bp)
+}
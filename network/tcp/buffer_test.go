@@ -0,0 +1,47 @@
+package tcp
+
+import "testing"
+
+func TestGetBuffer_SizeClass(t *testing.T) {
+	cases := []struct {
+		n, cap int
+	}{
+		{0, 64}, {1, 64}, {64, 64}, {65, 128}, {1000, 1024}, {1024, 1024},
+		{1025, 2048}, {64 * 1024, 64 * 1024},
+		//超过最大分级的不复用，按需分配
+		{64*1024 + 1, 64*1024 + 1},
+	}
+	for _, c := range cases {
+		b := GetBuffer(c.n)
+		if len(b) != c.n || cap(b) != c.cap {
+			t.Fatalf("GetBuffer(%d): expect len %d cap %d, got len %d cap %d", c.n, c.n, c.cap, len(b), cap(b))
+		}
+		PutBuffer(b)
+	}
+}
+
+func TestPutBuffer(t *testing.T) {
+	//sync.Pool不保证一定能取回，多试几次
+	var reused bool
+	for i := 0; i < 100 && !reused; i++ {
+		b := GetBuffer(100)
+		p := &b[:1][0]
+		//从中间开始的子切片容量不是分级的大小，会被忽略；从头开始的子切片可以归还
+		PutBuffer(b[10:10])
+		PutBuffer(b[:0])
+		reused = &GetBuffer(65)[:1][0] == p
+	}
+	if !reused {
+		t.Fatal("buffer not reused")
+	}
+
+	//容量不符合分级的切片会被忽略，之后获取的缓冲区容量仍然是分级的大小
+	for _, b := range [][]byte{make([]byte, 100), make([]byte, 127), make([]byte, 32), make([]byte, 128*1024), nil} {
+		PutBuffer(b)
+	}
+	for i := 0; i < 10; i++ {
+		if b := GetBuffer(100); cap(b) != 128 {
+			t.Fatalf("expect cap 128, got %d", cap(b))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"errors"
+	"github.com/YiuTerran/go-common/base/util/byteutil/crcutil"
+)
+
+var (
+	ErrMsgTooLong  = errors.New("message too long")
+	ErrMsgTooShort = errors.New("message too short")
+	// ErrChecksum 校验码不一致
+	ErrChecksum = errors.New("checksum mismatch")
+)
+
+// Checksum 帧校验，校验码在帧(去掉标识、反转义之后)的最后Size个字节
+// 解析之后返回的消息不包括校验码，写入时自动追加
+type Checksum struct {
+	// Size 校验码的字节数，1/2/4/8
+	Size         int
+	LittleEndian bool
+	// Skip 帧开头不参与校验的字节数
+	Skip int
+	// Sum 计算校验码，must goroutine safe
+	Sum func(data []byte) uint64
+}
+
+// CRCChecksum 使用crcutil中的算法，例如crcutil.CCITT
+func CRCChecksum(params *crcutil.Parameters) *Checksum {
+	table := crcutil.NewTable(params)
+	return &Checksum{Size: int(params.Width+7) / 8, Sum: table.CalculateCRC}
+}
+
+// XorChecksum 单字节异或校验，JT/T 808等协议使用
+func XorChecksum() *Checksum {
+	return &Checksum{Size: 1, Sum: func(data []byte) uint64 {
+		var x byte
+		for _, b := range data {
+			x ^= b
+		}
+		return uint64(x)
+	}}
+}
+
+// verify 校验并去掉校验码
+func (c *Checksum) verify(frame []byte) ([]byte, error) {
+	if c == nil {
+		return frame, nil
+	}
+	end := len(frame) - c.Size
+	if end < c.Skip {
+		return nil, ErrMsgTooShort
+	}
+	if c.decode(frame[end:]) != c.Sum(frame[c.Skip:end]) {
+		return nil, ErrChecksum
+	}
+	return frame[:end], nil
+}
+
+// put 计算frame[:len(frame)-Size]的校验码写入最后Size个字节
+func (c *Checksum) put(frame []byte) {
+	end := len(frame) - c.Size
+	sum := c.Sum(frame[c.Skip:end])
+	for i := 0; i < c.Size; i++ {
+		shift := 8 * uint(c.Size-1-i)
+		if c.LittleEndian {
+			shift = 8 * uint(i)
+		}
+		frame[end+i] = byte(sum >> shift)
+	}
+}
+
+func (c *Checksum) decode(b []byte) uint64 {
+	var sum uint64
+	for i := range b {
+		if c.LittleEndian {
+			sum |= uint64(b[i]) << (8 * uint(i))
+		} else {
+			sum = sum<<8 | uint64(b[i])
+		}
+	}
+	return sum
+}
+
+func (c *Checksum) size() int {
+	if c == nil {
+		return 0
+	}
+	return c.Size
+}
@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/base/structs/set"
 	"github.com/YiuTerran/go-common/network"
@@ -17,6 +18,10 @@ type Client struct {
 	AutoReconnect   bool
 	NewAgentFunc    func(*Conn) network.Session
 	Parser          IParser
+	// TLS 不为nil时使用tls，配置CertFile时向服务端出示客户端证书(mTLS)
+	TLS *TLSOptions
+
+	tlsConfig *tls.Config
 
 	cons      *set.Set[net.Conn]
 	wg        sync.WaitGroup
@@ -33,7 +38,6 @@ func NewClient(addr string, newAgentFunc func(*Conn) network.Session, options ..
 		AutoReconnect:   true,
 		Parser:          NewDefaultParser(),
 		NewAgentFunc:    newAgentFunc,
-		cons:            set.NewSet[net.Conn](),
 	}
 	for _, option := range options {
 		option(c)
@@ -59,6 +63,13 @@ func Parser(p IParser) Option {
 	}
 }
 
+// TLS 使用tls连接
+func TLS(opts *TLSOptions) Option {
+	return func(client *Client) {
+		client.TLS = opts
+	}
+}
+
 func (client *Client) Start() {
 	client.init()
 
@@ -90,6 +101,14 @@ func (client *Client) init() {
 	client.cons = set.NewSet[net.Conn]()
 	client.closeFlag = false
 
+	if client.TLS != nil {
+		cfg, err := client.TLS.ClientConfig(client.Addr)
+		if err != nil {
+			log.Fatal("invalid tls config:%v", err)
+		}
+		client.tlsConfig = cfg
+	}
+
 	if client.Parser == nil {
 		// msg parser
 		msgParser := NewDefaultParser()
@@ -100,6 +119,13 @@ func (client *Client) init() {
 func (client *Client) dial() net.Conn {
 	for {
 		conn, err := net.Dial("tcp", client.Addr)
+		if err == nil && client.tlsConfig != nil {
+			conn = tls.Client(conn, client.tlsConfig)
+			if err = handshake(conn, client.TLS.handshakeTimeout()); err != nil {
+				_ = conn.Close()
+				conn = nil
+			}
+		}
 		if err == nil || client.closeFlag {
 			return conn
 		}
@@ -128,18 +154,22 @@ reconnect:
 	client.cons.AddItem(conn)
 	client.Unlock()
 
-	tcpConn := newConn(conn, client.Parser)
+	tcpConn := newConn(conn, client.Parser, nil)
 	agent := client.NewAgentFunc(tcpConn)
 	agent.Run()
 
 	// cleanup
 	tcpConn.Close()
 	client.Lock()
-	client.cons.RemoveItem(conn)
+	//Close之后cons为nil
+	if client.cons != nil {
+		client.cons.RemoveItem(conn)
+	}
+	closed := client.closeFlag
 	client.Unlock()
 	agent.OnClose()
 
-	if client.AutoReconnect {
+	if client.AutoReconnect && !closed {
 		time.Sleep(client.ConnectInterval)
 		goto reconnect
 	}
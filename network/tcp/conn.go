@@ -1,49 +1,104 @@
 package tcp
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/base/structs/chanx"
+	"github.com/YiuTerran/go-common/network"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// maxCoalesce 一次writev最多合并的切片数
+const maxCoalesce = 64
+
 type Conn struct {
 	sync.Mutex
 	conn      net.Conn
-	writeChan *chanx.UnboundedChan[[]byte]
+	writeChan *chanx.UnboundedChan[net.Buffers]
 	closeFlag bool
 	parser    IParser
+	stats     *network.Stats
+
+	readTimeout  int64 //time.Duration
+	writeTimeout int64 //time.Duration
+
+	//读取相关的字段只在ReadMsg的协程中使用
+	reader *bufio.Reader
+	rbuf   []byte
 }
 
-func newConn(conn net.Conn, parser IParser) *Conn {
+// newConn stats为nil时不统计
+func newConn(conn net.Conn, parser IParser, stats *network.Stats) *Conn {
 	tcpConn := new(Conn)
 	tcpConn.conn = conn
-	tcpConn.writeChan = chanx.NewUnboundedChan[[]byte](100)
+	tcpConn.stats = stats
+	tcpConn.writeChan = chanx.NewUnboundedChan[net.Buffers](100)
 	tcpConn.parser = parser
 
-	go func() {
-		for b := range tcpConn.writeChan.Out {
-			if b == nil {
-				break
-			}
-			_, err := conn.Write(b)
-			if err != nil {
-				log.Error("fail to write tcp chan:%+v", err)
-				break
+	go tcpConn.writeLoop()
+
+	return tcpConn
+}
+
+// writeLoop 把队列中已有的消息合并之后用writev一次写入
+func (c *Conn) writeLoop() {
+	vec := make(net.Buffers, 0, maxCoalesce)
+	for closing := false; !closing; {
+		bufs, ok := <-c.writeChan.Out
+		if !ok || bufs == nil {
+			break
+		}
+		vec = append(vec[:0], bufs...)
+	coalesce:
+		for len(vec) < maxCoalesce {
+			select {
+			case bufs, ok = <-c.writeChan.Out:
+				if !ok || bufs == nil {
+					closing = true
+					break coalesce
+				}
+				vec = append(vec, bufs...)
+			default:
+				break coalesce
 			}
 		}
+		if wt := atomic.LoadInt64(&c.writeTimeout); wt > 0 {
+			_ = c.conn.SetWriteDeadline(time.Now().Add(time.Duration(wt)))
+		}
+		//WriteTo会修改切片，用副本写入
+		batch := vec
+		n, err := batch.WriteTo(c.conn)
+		c.stats.BytesOut(int(n))
+		for i := range vec {
+			vec[i] = nil
+		}
+		if err != nil {
+			log.Error("fail to write tcp chan:%+v", err)
+			break
+		}
+	}
 
-		_ = conn.Close()
-		tcpConn.Lock()
-		tcpConn.closeFlag = true
-		tcpConn.Unlock()
-	}()
-
-	return tcpConn
+	_ = c.conn.Close()
+	c.Lock()
+	c.closeFlag = true
+	c.Unlock()
 }
 
 func (c *Conn) doDestroy() {
-	_ = c.conn.(*net.TCPConn).SetLinger(0)
+	raw := c.conn
+	if tc, ok := raw.(*tls.Conn); ok {
+		raw = tc.NetConn()
+	}
+	if tc, ok := raw.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
 	_ = c.conn.Close()
 
 	if !c.closeFlag {
@@ -70,23 +125,97 @@ func (c *Conn) Close() {
 	c.closeFlag = true
 }
 
-func (c *Conn) doWrite(b []byte) {
-	c.writeChan.In <- b
+func (c *Conn) doWrite(bufs net.Buffers) {
+	c.writeChan.In <- bufs
 }
 
 // b must not be modified by the others goroutines
 func (c *Conn) Write(b []byte) {
+	if b == nil {
+		return
+	}
+	c.WriteBuffers(net.Buffers{b})
+}
+
+// WriteBuffers 不复制数据，和队列中的其他消息合并之后用writev写入
+// 写入完成之前bufs中的切片都不能被修改
+func (c *Conn) WriteBuffers(bufs net.Buffers) {
 	c.Lock()
 	defer c.Unlock()
-	if c.closeFlag || b == nil {
+	if c.closeFlag || len(bufs) == 0 {
 		return
 	}
 
-	c.doWrite(b)
+	c.doWrite(bufs)
+}
+
+// ReleaseMsg 归还ReadMsg返回的消息的缓冲区，之后不能再使用msg
+// 内置的parser读取的消息都来自缓冲区池，不调用也不会泄漏
+func (c *Conn) ReleaseMsg(msg []byte) {
+	PutBuffer(msg)
 }
 
+// Read 读取原始数据，使用过Reader之后从Reader中读取
 func (c *Conn) Read(b []byte) (int, error) {
-	return c.conn.Read(b)
+	if c.reader != nil {
+		return c.reader.Read(b)
+	}
+	return c.readRaw(b)
+}
+
+func (c *Conn) readRaw(b []byte) (int, error) {
+	n, err := c.conn.Read(b)
+	c.stats.BytesIn(n)
+	return n, err
+}
+
+type rawReader struct {
+	c *Conn
+}
+
+func (r rawReader) Read(b []byte) (int, error) {
+	return r.c.readRaw(b)
+}
+
+// Reader 带缓冲的reader，第一次调用时创建，之后Read也会从中读取，不会丢失缓冲的数据
+// 只能在ReadMsg的协程中(即IParser.Read中)使用
+func (c *Conn) Reader() *bufio.Reader {
+	if c.reader == nil {
+		c.reader = bufio.NewReader(rawReader{c: c})
+	}
+	return c.reader
+}
+
+// readDelimited 读取到delim为止(包括delim)，返回的切片复用连接的缓冲区，只在下一次读取之前有效
+func (c *Conn) readDelimited(delim []byte, maxLen int) ([]byte, error) {
+	r := c.Reader()
+	last := delim[len(delim)-1]
+	c.rbuf = c.rbuf[:0]
+	for {
+		s, err := r.ReadSlice(last)
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if len(c.rbuf)+len(s) > maxLen+len(delim) {
+			return nil, ErrMsgTooLong
+		}
+		c.rbuf = append(c.rbuf, s...)
+		if err == nil && bytes.HasSuffix(c.rbuf, delim) {
+			return c.rbuf, nil
+		}
+	}
+}
+
+// readFull 读取n个字节，返回的切片复用连接的缓冲区，只在下一次读取之前有效
+func (c *Conn) readFull(n int) ([]byte, error) {
+	if cap(c.rbuf) < n {
+		c.rbuf = make([]byte, n)
+	}
+	c.rbuf = c.rbuf[:n]
+	if _, err := io.ReadFull(c.Reader(), c.rbuf); err != nil {
+		return nil, err
+	}
+	return c.rbuf, nil
 }
 
 func (c *Conn) LocalAddr() net.Addr {
@@ -97,10 +226,77 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// ConnectionState tls连接的状态，不是tls连接时返回false
+func (c *Conn) ConnectionState() (tls.ConnectionState, bool) {
+	tc, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tc.ConnectionState(), true
+}
+
+// PeerCertificates 对端的证书链，第一个是对端自己的证书，不是tls连接或者对端没有出示证书时为空
+func (c *Conn) PeerCertificates() []*x509.Certificate {
+	state, ok := c.ConnectionState()
+	if !ok {
+		return nil
+	}
+	return state.PeerCertificates
+}
+
+// SetReadTimeout 每次ReadMsg等待消息的超时，0表示不超时
+func (c *Conn) SetReadTimeout(d time.Duration) {
+	atomic.StoreInt64(&c.readTimeout, int64(d))
+	if d <= 0 {
+		_ = c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// SetWriteTimeout 每次写入的超时，超时之后连接会被关闭，0表示不超时
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	atomic.StoreInt64(&c.writeTimeout, int64(d))
+	if d <= 0 {
+		_ = c.conn.SetWriteDeadline(time.Time{})
+	}
+}
+
 func (c *Conn) ReadMsg() ([]byte, error) {
-	return c.parser.Read(c)
+	if rt := atomic.LoadInt64(&c.readTimeout); rt > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(time.Duration(rt)))
+	}
+	msg, err := c.parser.Read(c)
+	if err != nil {
+		c.stats.ReadError(err)
+		return nil, err
+	}
+	c.stats.MsgIn()
+	return msg, nil
 }
 
+// WriteMsg 使用parser写入，内置的parser都会复制args，返回之后args可以被复用
 func (c *Conn) WriteMsg(args ...[]byte) error {
-	return c.parser.Write(c, args...)
+	if err := c.parser.Write(c, args...); err != nil {
+		return err
+	}
+	c.stats.MsgOut()
+	return nil
+}
+
+// WriteMsgNoCopy parser实现了NoCopyParser时不复制args，否则同WriteMsg
+// 调用之后args归连接所有，写入完成之前不能被修改，也不能归还到缓冲区池
+func (c *Conn) WriteMsgNoCopy(args ...[]byte) error {
+	p, ok := c.parser.(NoCopyParser)
+	if !ok {
+		return c.WriteMsg(args...)
+	}
+	if err := p.WriteNoCopy(c, args...); err != nil {
+		return err
+	}
+	c.stats.MsgOut()
+	return nil
+}
+
+// WriteQueueLen 写队列中等待写入的消息数
+func (c *Conn) WriteQueueLen() int {
+	return c.writeChan.Len()
 }
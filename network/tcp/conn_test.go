@@ -0,0 +1,252 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/go-common/base/structs/chanx"
+)
+
+const benchMsgLen = 128
+
+// streamConn 不断重复同一个帧的net.Conn，写入的数据直接丢弃
+type streamConn struct {
+	net.Conn
+	frame []byte
+	off   int
+}
+
+func newStreamConn() *streamConn {
+	frame := make([]byte, 2+benchMsgLen)
+	binary.BigEndian.PutUint16(frame, benchMsgLen)
+	return &streamConn{frame: frame}
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	var n int
+	for n < len(b) {
+		m := copy(b[n:], c.frame[c.off:])
+		n += m
+		c.off = (c.off + m) % len(c.frame)
+	}
+	return n, nil
+}
+
+func (c *streamConn) Write(b []byte) (int, error)      { return len(b), nil }
+func (c *streamConn) Close() error                     { return nil }
+func (c *streamConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *streamConn) SetDeadline(time.Time) error      { return nil }
+
+// legacyRead 之前的实现：不带缓冲，每个消息分配一次
+func legacyRead(conn net.Conn) ([]byte, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(b[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func BenchmarkBinaryParser_Read(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		conn := newStreamConn()
+		b.SetBytes(benchMsgLen)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := legacyRead(conn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		c := newConn(newStreamConn(), NewDefaultParser(), nil)
+		defer c.Destroy()
+		p := NewDefaultParser()
+		b.SetBytes(benchMsgLen)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			msg, err := p.Read(c)
+			if err != nil {
+				b.Fatal(err)
+			}
+			c.ReleaseMsg(msg)
+		}
+	})
+}
+
+// loopback 本地tcp连接，返回写入端和接收端已经收到的字节数
+func loopback(b *testing.B) (net.Conn, *int64) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	var received int64
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := conn.Read(buf)
+			atomic.AddInt64(&received, int64(n))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return conn, &received
+}
+
+func waitReceived(received *int64, total int64) {
+	for atomic.LoadInt64(received) < total {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkBinaryParser_Write(b *testing.B) {
+	body := make([]byte, benchMsgLen)
+	frameLen := int64(2 + benchMsgLen)
+	b.Run("legacy", func(b *testing.B) {
+		conn, received := loopback(b)
+		defer conn.Close()
+		//之前的实现：合并成一个新的切片，写协程每个消息调用一次Write
+		ch := chanx.NewUnboundedChan[[]byte](100)
+		go func() {
+			for msg := range ch.Out {
+				if _, err := conn.Write(msg); err != nil {
+					return
+				}
+			}
+		}()
+		b.SetBytes(benchMsgLen)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			msg := make([]byte, frameLen)
+			binary.BigEndian.PutUint16(msg, benchMsgLen)
+			copy(msg[2:], body)
+			ch.In <- msg
+		}
+		waitReceived(received, frameLen*int64(b.N))
+		ch.Close()
+	})
+	p := NewDefaultParser()
+	for name, write := range map[string]func(*Conn, ...[]byte) error{"copy": p.Write, "nocopy": p.WriteNoCopy} {
+		write := write
+		b.Run(name, func(b *testing.B) {
+			conn, received := loopback(b)
+			c := newConn(conn, nil, nil)
+			defer c.Destroy()
+			b.SetBytes(benchMsgLen)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := write(c, body); err != nil {
+					b.Fatal(err)
+				}
+			}
+			waitReceived(received, frameLen*int64(b.N))
+		})
+	}
+}
+
+// blockedConn 写协程阻塞在第一次Write，返回连接和解除阻塞的函数
+func blockedConn(t *testing.T) (*Conn, *memConn, func()) {
+	mc := newMemConn(nil)
+	mc.blocked = make(chan struct{})
+	c := newConn(mc, nil, nil)
+	t.Cleanup(c.Destroy)
+	var once sync.Once
+	release := func() { once.Do(func() { close(mc.blocked) }) }
+	t.Cleanup(release)
+	return c, mc, release
+}
+
+func TestConn_WriteOrder(t *testing.T) {
+	c, mc, release := blockedConn(t)
+	//超过maxCoalesce，需要分多次合并写入
+	const n = 3 * maxCoalesce
+	var expect []byte
+	for i := 0; i < n; i++ {
+		c.WriteBuffers(net.Buffers{{byte(i)}, {byte(i), byte(i)}})
+		expect = append(expect, byte(i), byte(i), byte(i))
+	}
+	c.Write(nil)
+	if c.WriteQueueLen() == 0 {
+		t.Fatal("expect queued messages")
+	}
+	release()
+	if data := mc.written(t, len(expect)); !bytes.Equal(data, expect) {
+		t.Fatalf("expect %x, got %x", expect, data)
+	}
+}
+
+func TestConn_CloseWhileQueued(t *testing.T) {
+	c, mc, release := blockedConn(t)
+	for i := 0; i < 100; i++ {
+		c.Write([]byte{byte(i)})
+	}
+	//Close之后不再接受新的消息，已经在队列中的消息写完之后再关闭连接
+	c.Close()
+	c.Write([]byte("late"))
+	release()
+	for deadline := time.Now().Add(3 * time.Second); !mc.isClosed(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("conn not closed")
+		}
+	}
+	if data := mc.written(t, 100); len(data) != 100 || data[99] != 99 {
+		t.Fatalf("unexpected data %x", data)
+	}
+
+	//Destroy直接关闭连接，丢弃队列中的消息
+	c, mc, release = blockedConn(t)
+	for i := 0; i < 100; i++ {
+		c.Write([]byte{byte(i)})
+	}
+	c.Destroy()
+	c.Write([]byte("late"))
+	release()
+	time.Sleep(10 * time.Millisecond)
+	if data := mc.written(t, 0); len(data) != 0 {
+		t.Fatalf("expect nothing written after destroy, got %x", data)
+	}
+}
+
+func TestBinaryParser_WriteCopy(t *testing.T) {
+	mc := newMemConn(nil)
+	mc.blocked = make(chan struct{})
+	c := newConn(mc, NewDefaultParser(), nil)
+	t.Cleanup(c.Destroy)
+	//WriteMsg返回之后参数可以马上复用
+	msg := []byte("abc")
+	if err := c.WriteMsg(msg, []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	copy(msg, "xyz")
+	//WriteMsgNoCopy直接放入写队列
+	if err := c.WriteMsgNoCopy(msg); err != nil {
+		t.Fatal(err)
+	}
+	close(mc.blocked)
+	if data := mc.written(t, 11); string(data) != "\x00\x04abcd\x00\x03xyz" {
+		t.Fatalf("unexpected data %q", data)
+	}
+	if err := c.WriteMsgNoCopy(); err == nil {
+		t.Fatal("expect message too short")
+	}
+}
@@ -0,0 +1,303 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"io"
+)
+
+/**  常见的设备协议分包方式，都实现了IParser
+  *  DelimiterParser：以分隔符结尾，例如按行分割的文本协议
+  *  EscapeParser：以标识位开头和结尾，内容中的标识位需要转义，例如JT/T 808
+  *  LengthFieldParser：固定的头部中某个位置是长度字段
+  *  读取时复用连接的缓冲区，返回的消息来自缓冲区池(见buffer.go)；可以配置Checksum校验
+**/
+
+const defaultMaxFrameLen = 64 * 1024
+
+// DelimiterParser 以分隔符结尾的协议，返回的消息不包括分隔符
+type DelimiterParser struct {
+	Delimiter []byte
+	// MaxMsgLen 不包括分隔符的最大长度，默认64K
+	MaxMsgLen int
+	Checksum  *Checksum
+
+	trimCR bool
+}
+
+func NewDelimiterParser(delimiter []byte) *DelimiterParser {
+	if len(delimiter) == 0 {
+		log.Fatal("delimiter must not be empty")
+	}
+	return &DelimiterParser{Delimiter: delimiter, MaxMsgLen: defaultMaxFrameLen}
+}
+
+// NewLineParser 按行分割的文本协议，读取时兼容\r\n，写入时使用\n
+func NewLineParser() *DelimiterParser {
+	p := NewDelimiterParser([]byte("\n"))
+	p.trimCR = true
+	return p
+}
+
+// Read 从连接中读取数据，goroutine safe
+func (p *DelimiterParser) Read(conn *Conn) ([]byte, error) {
+	raw, err := conn.readDelimited(p.Delimiter, p.MaxMsgLen)
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[:len(raw)-len(p.Delimiter)]
+	if p.trimCR {
+		raw = bytes.TrimSuffix(raw, []byte("\r"))
+	}
+	if raw, err = p.Checksum.verify(raw); err != nil {
+		return nil, err
+	}
+	msg := GetBuffer(len(raw))
+	copy(msg, raw)
+	return msg, nil
+}
+
+// Write 向连接中写入数据，goroutine safe；内容中不能包含分隔符
+func (p *DelimiterParser) Write(conn *Conn, args ...[]byte) error {
+	msgLen := argsLen(args) + p.Checksum.size()
+	if msgLen > p.MaxMsgLen {
+		return ErrMsgTooLong
+	}
+	msg := make([]byte, msgLen, msgLen+len(p.Delimiter))
+	mergeInto(msg, args)
+	if p.Checksum != nil {
+		p.Checksum.put(msg)
+	}
+	conn.Write(append(msg, p.Delimiter...))
+	return nil
+}
+
+// EscapeParser 以Flag开头和结尾，内容中的特殊字节用Escape+转义码代替
+// 返回的消息是去掉标识位、反转义、去掉校验码之后的内容
+type EscapeParser struct {
+	Flag   byte
+	Escape byte
+	// MaxMsgLen 反转义之前(不包括标识位)的最大长度，默认64K
+	MaxMsgLen int
+	Checksum  *Checksum
+
+	escapes   [256]int16 //原始字节 -> 转义码，-1表示不需要转义
+	unescapes [256]int16 //转义码 -> 原始字节
+}
+
+// NewEscapeParser escapes是原始字节到转义码的映射，需要包括Flag和Escape本身
+func NewEscapeParser(flag, escape byte, escapes map[byte]byte) *EscapeParser {
+	p := &EscapeParser{Flag: flag, Escape: escape, MaxMsgLen: defaultMaxFrameLen}
+	for i := range p.escapes {
+		p.escapes[i], p.unescapes[i] = -1, -1
+	}
+	for raw, code := range escapes {
+		p.escapes[raw] = int16(code)
+		p.unescapes[code] = int16(raw)
+	}
+	if p.escapes[flag] < 0 || p.escapes[escape] < 0 {
+		log.Fatal("flag and escape byte must be escaped")
+	}
+	return p
+}
+
+// NewJT808Parser JT/T 808协议：0x7e为标识位，0x7e -> 0x7d 0x02，0x7d -> 0x7d 0x01，校验码为单字节异或
+func NewJT808Parser() *EscapeParser {
+	p := NewEscapeParser(0x7e, 0x7d, map[byte]byte{0x7e: 0x02, 0x7d: 0x01})
+	p.Checksum = XorChecksum()
+	return p
+}
+
+// Read 从连接中读取数据，goroutine safe
+// 标识位之前的数据会被丢弃，连续的两个标识位之间没有内容时，后一个作为起始标识
+func (p *EscapeParser) Read(conn *Conn) ([]byte, error) {
+	flag := []byte{p.Flag}
+	raw, err := conn.readDelimited(flag, p.MaxMsgLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 1 {
+		log.Debug("drop %d bytes before frame flag from %v", len(raw)-1, conn.RemoteAddr())
+	}
+	for {
+		if raw, err = conn.readDelimited(flag, p.MaxMsgLen); err != nil {
+			return nil, err
+		}
+		if len(raw) > 1 {
+			break
+		}
+	}
+	frame, err := p.unescape(raw[:len(raw)-1])
+	if err != nil {
+		return nil, err
+	}
+	return verifyFrame(p.Checksum, frame)
+}
+
+func (p *EscapeParser) unescape(raw []byte) ([]byte, error) {
+	//反转义之后不会变长
+	frame := GetBuffer(len(raw))[:0]
+	for i := 0; i < len(raw); i++ {
+		b := raw[i]
+		if b == p.Escape {
+			i++
+			if i == len(raw) || p.unescapes[raw[i]] < 0 {
+				PutBuffer(frame)
+				return nil, fmt.Errorf("invalid escape sequence at %d", i-1)
+			}
+			b = byte(p.unescapes[raw[i]])
+		}
+		frame = append(frame, b)
+	}
+	return frame, nil
+}
+
+// Write 向连接中写入数据，goroutine safe
+func (p *EscapeParser) Write(conn *Conn, args ...[]byte) error {
+	frame := make([]byte, argsLen(args)+p.Checksum.size())
+	mergeInto(frame, args)
+	if p.Checksum != nil {
+		p.Checksum.put(frame)
+	}
+	msg := make([]byte, 0, len(frame)+len(frame)/8+2)
+	msg = append(msg, p.Flag)
+	for _, b := range frame {
+		if code := p.escapes[b]; code >= 0 {
+			msg = append(msg, p.Escape, byte(code))
+		} else {
+			msg = append(msg, b)
+		}
+	}
+	if len(msg)-1 > p.MaxMsgLen {
+		return ErrMsgTooLong
+	}
+	conn.Write(append(msg, p.Flag))
+	return nil
+}
+
+// LengthFieldParser 头部中有长度字段的协议，返回整个帧(包括头部，不包括校验码)
+// 帧的总长度 = LengthOffset + LengthSize + 长度字段的值 + LengthAdjustment
+type LengthFieldParser struct {
+	LengthOffset int
+	// LengthSize 长度字段的字节数，1/2/4
+	LengthSize   int
+	LittleEndian bool
+	// LengthAdjustment 长度字段的值不是长度字段之后所有字节数时的修正，可以为负数
+	LengthAdjustment int
+	// MinMsgLen MaxMsgLen 整个帧的长度限制，默认最大64K
+	MinMsgLen int
+	MaxMsgLen int
+	Checksum  *Checksum
+}
+
+func NewLengthFieldParser(lengthOffset, lengthSize, lengthAdjustment int) *LengthFieldParser {
+	if lengthSize != 1 && lengthSize != 2 && lengthSize != 4 {
+		log.Fatal("invalid length field size %d", lengthSize)
+	}
+	return &LengthFieldParser{
+		LengthOffset:     lengthOffset,
+		LengthSize:       lengthSize,
+		LengthAdjustment: lengthAdjustment,
+		MaxMsgLen:        defaultMaxFrameLen,
+	}
+}
+
+func (p *LengthFieldParser) headerLen() int {
+	return p.LengthOffset + p.LengthSize
+}
+
+// Read 从连接中读取数据，goroutine safe
+func (p *LengthFieldParser) Read(conn *Conn) ([]byte, error) {
+	header, err := conn.readFull(p.headerLen())
+	if err != nil {
+		return nil, err
+	}
+	var length int
+	field := header[p.LengthOffset:]
+	switch p.LengthSize {
+	case 1:
+		length = int(field[0])
+	case 2:
+		length = int(p.byteOrder().Uint16(field))
+	case 4:
+		length = int(p.byteOrder().Uint32(field))
+	}
+	frameLen := p.headerLen() + length + p.LengthAdjustment
+	if frameLen > p.MaxMsgLen {
+		return nil, ErrMsgTooLong
+	} else if frameLen < p.MinMsgLen || frameLen < p.headerLen() {
+		return nil, ErrMsgTooShort
+	}
+	frame := GetBuffer(frameLen)
+	copy(frame, header)
+	if _, err = io.ReadFull(conn.Reader(), frame[p.headerLen():]); err != nil {
+		PutBuffer(frame)
+		return nil, err
+	}
+	return verifyFrame(p.Checksum, frame)
+}
+
+// Write 向连接中写入数据，goroutine safe
+// args是包括头部的整个帧(不包括校验码)，长度字段和校验码会自动填写
+func (p *LengthFieldParser) Write(conn *Conn, args ...[]byte) error {
+	frameLen := argsLen(args) + p.Checksum.size()
+	if frameLen > p.MaxMsgLen {
+		return ErrMsgTooLong
+	} else if frameLen < p.MinMsgLen || frameLen < p.headerLen() {
+		return ErrMsgTooShort
+	}
+	length := frameLen - p.headerLen() - p.LengthAdjustment
+	if length < 0 || (p.LengthSize < 4 && length >= 1<<(8*uint(p.LengthSize))) {
+		return fmt.Errorf("invalid length field value %d", length)
+	}
+	frame := make([]byte, frameLen)
+	mergeInto(frame, args)
+	field := frame[p.LengthOffset:]
+	switch p.LengthSize {
+	case 1:
+		field[0] = byte(length)
+	case 2:
+		p.byteOrder().PutUint16(field, uint16(length))
+	case 4:
+		p.byteOrder().PutUint32(field, uint32(length))
+	}
+	if p.Checksum != nil {
+		p.Checksum.put(frame)
+	}
+	conn.Write(frame)
+	return nil
+}
+
+func (p *LengthFieldParser) byteOrder() binary.ByteOrder {
+	if p.LittleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// verifyFrame 校验来自缓冲区池的frame，失败时归还
+func verifyFrame(c *Checksum, frame []byte) ([]byte, error) {
+	msg, err := c.verify(frame)
+	if err != nil {
+		PutBuffer(frame)
+	}
+	return msg, err
+}
+
+func argsLen(args [][]byte) int {
+	var n int
+	for _, arg := range args {
+		n += len(arg)
+	}
+	return n
+}
+
+// mergeInto 把args依次复制到dst开头
+func mergeInto(dst []byte, args [][]byte) {
+	var l int
+	for _, arg := range args {
+		l += copy(dst[l:], arg)
+	}
+}
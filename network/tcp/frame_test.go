@@ -0,0 +1,316 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/go-common/base/util/byteutil/crcutil"
+)
+
+// memConn 从r读取，写入的数据按Write调用依次记录
+type memConn struct {
+	net.Conn
+	r *bytes.Reader
+
+	mu      sync.Mutex
+	writes  [][]byte
+	closed  bool
+	blocked chan struct{} //不为nil时，Write等到关闭之后才返回
+}
+
+func newMemConn(input []byte) *memConn {
+	return &memConn{r: bytes.NewReader(input)}
+}
+
+func (c *memConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *memConn) Write(b []byte) (int, error) {
+	if c.blocked != nil {
+		<-c.blocked
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *memConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *memConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *memConn) LocalAddr() net.Addr              { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+func (c *memConn) RemoteAddr() net.Addr             { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+func (c *memConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *memConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *memConn) SetDeadline(time.Time) error      { return nil }
+
+// written 等待写入至少n字节，返回写入的所有数据
+func (c *memConn) written(t *testing.T, n int) []byte {
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		data := bytes.Join(c.writes, nil)
+		c.mu.Unlock()
+		if len(data) >= n {
+			return data
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d bytes written, got %x", n, data)
+		}
+	}
+}
+
+// newMemTCPConn input作为对端发来的数据
+func newMemTCPConn(t *testing.T, input []byte) (*Conn, *memConn) {
+	mc := newMemConn(input)
+	c := newConn(mc, nil, nil)
+	t.Cleanup(c.Destroy)
+	return c, mc
+}
+
+// readAll 用p读取所有消息，直到出错为止
+func readAll(p IParser, c *Conn) ([]string, error) {
+	var msgs []string
+	for {
+		msg, err := p.Read(c)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, string(msg))
+		c.ReleaseMsg(msg)
+	}
+}
+
+func expectMsgs(t *testing.T, msgs []string, err error, expect ...string) {
+	t.Helper()
+	if err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+	if strings.Join(msgs, "|") != strings.Join(expect, "|") {
+		t.Fatalf("expect %q, got %q", expect, msgs)
+	}
+}
+
+func TestEscapeParser_JT808(t *testing.T) {
+	body := []byte{0x30, 0x7e, 0x08, 0x7d, 0x55}
+	//0x7e和0x7d需要转义，校验码0x6e是body的异或
+	wire := []byte{0x7e, 0x30, 0x7d, 0x02, 0x08, 0x7d, 0x01, 0x55, 0x6e, 0x7e}
+	p := NewJT808Parser()
+
+	c, mc := newMemTCPConn(t, nil)
+	if err := p.Write(c, body[:2], body[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if data := mc.written(t, len(wire)); !bytes.Equal(data, wire) {
+		t.Fatalf("expect %x, got %x", wire, data)
+	}
+
+	//标识位之前的数据丢弃，连续的标识位之间没有内容时跳过
+	input := append([]byte{0x01, 0x02}, wire...)
+	input = append(input, 0x7e)
+	input = append(input, wire...)
+	c, _ = newMemTCPConn(t, input)
+	msgs, err := readAll(p, c)
+	expectMsgs(t, msgs, err, string(body), string(body))
+}
+
+func TestEscapeParser_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  []byte
+		maxLen int
+		expect string
+	}{
+		{"unknown escape code", []byte{0x7e, 0x30, 0x7d, 0x03, 0x30, 0x7e}, 0, "invalid escape sequence at 1"},
+		{"escape at end", []byte{0x7e, 0x30, 0x30, 0x7d, 0x7e}, 0, "invalid escape sequence at 2"},
+		{"checksum mismatch", []byte{0x7e, 0x30, 0x31, 0x00, 0x7e}, 0, ErrChecksum.Error()},
+		{"empty frames", []byte{0x7e, 0x7e, 0x7e}, 0, io.EOF.Error()},
+		{"too long", []byte{0x7e, 1, 2, 3, 4, 5, 6, 7, 8, 0x7e}, 4, ErrMsgTooLong.Error()},
+	}
+	for _, cs := range cases {
+		p := NewJT808Parser()
+		if cs.maxLen > 0 {
+			p.MaxMsgLen = cs.maxLen
+		}
+		c, _ := newMemTCPConn(t, cs.input)
+		if _, err := p.Read(c); err == nil || err.Error() != cs.expect {
+			t.Fatalf("%s: expect %s, got %v", cs.name, cs.expect, err)
+		}
+	}
+
+	p := NewJT808Parser()
+	p.MaxMsgLen = 4
+	c, _ := newMemTCPConn(t, nil)
+	//转义之后超过最大长度
+	if err := p.Write(c, []byte{0x7e, 0x7e}); !errors.Is(err, ErrMsgTooLong) {
+		t.Fatalf("expect ErrMsgTooLong, got %v", err)
+	}
+}
+
+func TestDelimiterParser_Line(t *testing.T) {
+	p := NewLineParser()
+	c, _ := newMemTCPConn(t, []byte("hello\r\nworld\n\n"))
+	msgs, err := readAll(p, c)
+	expectMsgs(t, msgs, err, "hello", "world", "")
+
+	c, mc := newMemTCPConn(t, nil)
+	if err = p.Write(c, []byte("hi"), []byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if data := mc.written(t, 4); string(data) != "hi!\n" {
+		t.Fatalf("expect hi!\\n, got %q", data)
+	}
+
+	//最大长度不包括分隔符
+	p.MaxMsgLen = 5
+	c, _ = newMemTCPConn(t, []byte("hello\ntoolong\n"))
+	if msg, err := p.Read(c); err != nil || string(msg) != "hello" {
+		t.Fatalf("expect hello, got %q %v", msg, err)
+	}
+	if _, err = p.Read(c); !errors.Is(err, ErrMsgTooLong) {
+		t.Fatalf("expect ErrMsgTooLong, got %v", err)
+	}
+	if err = p.Write(c, []byte("toolong")); !errors.Is(err, ErrMsgTooLong) {
+		t.Fatalf("expect ErrMsgTooLong, got %v", err)
+	}
+}
+
+func TestDelimiterParser_Checksum(t *testing.T) {
+	p := NewDelimiterParser([]byte("##"))
+	p.Checksum = XorChecksum()
+	c, mc := newMemTCPConn(t, nil)
+	if err := p.Write(c, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	wire := mc.written(t, 6)
+	if expect := []byte{'a', 'b', 'c', 'a' ^ 'b' ^ 'c', '#', '#'}; !bytes.Equal(wire, expect) {
+		t.Fatalf("expect %x, got %x", expect, wire)
+	}
+	c, _ = newMemTCPConn(t, append(wire, "abcd##"...))
+	if msg, err := p.Read(c); err != nil || string(msg) != "abc" {
+		t.Fatalf("expect abc, got %q %v", msg, err)
+	}
+	if _, err := p.Read(c); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expect ErrChecksum, got %v", err)
+	}
+}
+
+func TestLengthFieldParser(t *testing.T) {
+	//长度字段的值是整个帧的长度(包括头部和校验码)，所以修正值是头部长度的相反数
+	p := NewLengthFieldParser(1, 2, -3)
+	p.Checksum = XorChecksum()
+	c, mc := newMemTCPConn(t, nil)
+	if err := p.Write(c, []byte{'T', 0, 0}, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	frame := []byte{'T', 0, 7, 'a', 'b', 'c'}
+	var x byte
+	for _, b := range frame {
+		x ^= b
+	}
+	wire := append(frame, x)
+	if data := mc.written(t, len(wire)); !bytes.Equal(data, wire) {
+		t.Fatalf("expect %x, got %x", wire, data)
+	}
+	c, _ = newMemTCPConn(t, append(wire, wire...))
+	msgs, err := readAll(p, c)
+	expectMsgs(t, msgs, err, string(frame), string(frame))
+
+	//长度字段的值是之后的字节数
+	p = NewLengthFieldParser(0, 1, 0)
+	p.LittleEndian = true
+	c, _ = newMemTCPConn(t, []byte{2, 'h', 'i', 0, 1, 'x'})
+	msgs, err = readAll(p, c)
+	expectMsgs(t, msgs, err, "\x02hi", "\x00", "\x01x")
+}
+
+func TestLengthFieldParser_Errors(t *testing.T) {
+	p := NewLengthFieldParser(1, 2, -3)
+	p.MaxMsgLen = 6
+	c, _ := newMemTCPConn(t, []byte{'T', 0, 7, 'a', 'b', 'c', 'd'})
+	if _, err := p.Read(c); !errors.Is(err, ErrMsgTooLong) {
+		t.Fatalf("expect ErrMsgTooLong, got %v", err)
+	}
+	//帧长度小于头部
+	c, _ = newMemTCPConn(t, []byte{'T', 0, 1})
+	if _, err := p.Read(c); !errors.Is(err, ErrMsgTooShort) {
+		t.Fatalf("expect ErrMsgTooShort, got %v", err)
+	}
+	c, _ = newMemTCPConn(t, []byte{'T', 0, 5, 'a'})
+	if _, err := p.Read(c); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect ErrUnexpectedEOF, got %v", err)
+	}
+	if err := p.Write(c, []byte("Tooooooo")); !errors.Is(err, ErrMsgTooLong) {
+		t.Fatalf("expect ErrMsgTooLong, got %v", err)
+	}
+	if err := p.Write(c, []byte("T")); !errors.Is(err, ErrMsgTooShort) {
+		t.Fatalf("expect ErrMsgTooShort, got %v", err)
+	}
+
+	//长度字段放不下
+	p = NewLengthFieldParser(0, 1, 0)
+	if err := p.Write(c, make([]byte, 300)); err == nil {
+		t.Fatal("expect invalid length field value")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	data := []byte("123456789")
+	cases := []struct {
+		c      *Checksum
+		expect []byte
+	}{
+		{CRCChecksum(crcutil.XMODEM), []byte{0x31, 0xc3}},
+		{&Checksum{Size: 2, LittleEndian: true, Sum: crcutil.NewTable(crcutil.XMODEM).CalculateCRC},
+			[]byte{0xc3, 0x31}},
+		{CRCChecksum(crcutil.CRC32), []byte{0xcb, 0xf4, 0x39, 0x26}},
+		{XorChecksum(), []byte{'1' ^ '2' ^ '3' ^ '4' ^ '5' ^ '6' ^ '7' ^ '8' ^ '9'}},
+	}
+	for i, cs := range cases {
+		frame := append(append([]byte(nil), data...), make([]byte, cs.c.Size)...)
+		cs.c.put(frame)
+		if !bytes.Equal(frame[len(data):], cs.expect) {
+			t.Fatalf("case %d: expect %x, got %x", i, cs.expect, frame[len(data):])
+		}
+		if msg, err := cs.c.verify(frame); err != nil || !bytes.Equal(msg, data) {
+			t.Fatalf("case %d: verify failed %q %v", i, msg, err)
+		}
+		frame[0]++
+		if _, err := cs.c.verify(frame); !errors.Is(err, ErrChecksum) {
+			t.Fatalf("case %d: expect ErrChecksum, got %v", i, err)
+		}
+	}
+
+	//Skip之前的字节不参与校验
+	c := XorChecksum()
+	c.Skip = 2
+	frame := []byte{0xff, 0xff, 1, 2, 0}
+	c.put(frame)
+	if frame[4] != 3 {
+		t.Fatalf("expect 3, got %d", frame[4])
+	}
+	frame[0] = 0
+	if _, err := c.verify(frame); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.verify([]byte{1, 2}); !errors.Is(err, ErrMsgTooShort) {
+		t.Fatalf("expect ErrMsgTooShort, got %v", err)
+	}
+}
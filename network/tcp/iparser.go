@@ -7,6 +7,12 @@ type IParser interface {
 	Write(conn *Conn, args ...[]byte) error
 }
 
+// NoCopyParser 可选接口，写入时不复制args，Conn.WriteMsgNoCopy使用
+// 写入完成之前args不能被修改
+type NoCopyParser interface {
+	WriteNoCopy(conn *Conn, args ...[]byte) error
+}
+
 // DirectlyWrite 直接写入字节码，工具函数
 func DirectlyWrite(conn *Conn, args ...[]byte) error {
 	var msgLen uint32
@@ -6,6 +6,7 @@ import (
 	"github.com/YiuTerran/go-common/base/log"
 	"io"
 	"math"
+	"net"
 )
 
 // BinaryParser 一个默认的二进制解析器，可以拿来做服务端封装
@@ -53,11 +54,9 @@ func (p *BinaryParser) setMsgLen(lenMsgLen int) {
 
 // Read 从连接中读取数据，goroutine safe
 func (p *BinaryParser) Read(conn *Conn) ([]byte, error) {
-	var b [4]byte
-	bufMsgLen := b[:p.lenMsgLen]
-
 	// read len
-	if _, err := io.ReadFull(conn, bufMsgLen); err != nil {
+	bufMsgLen, err := conn.readFull(p.lenMsgLen)
+	if err != nil {
 		return nil, err
 	}
 
@@ -87,9 +86,10 @@ func (p *BinaryParser) Read(conn *Conn) ([]byte, error) {
 		return nil, errors.New("message too short")
 	}
 
-	// data
-	msgData := make([]byte, msgLen)
-	if _, err := io.ReadFull(conn, msgData); err != nil {
+	// data，来自缓冲区池，可以用Conn.ReleaseMsg归还
+	msgData := GetBuffer(int(msgLen))
+	if _, err := io.ReadFull(conn.Reader(), msgData); err != nil {
+		PutBuffer(msgData)
 		return nil, err
 	}
 
@@ -97,7 +97,54 @@ func (p *BinaryParser) Read(conn *Conn) ([]byte, error) {
 }
 
 // Write 向连接中写入数据，goroutine safe
+// 会复制args，返回之后args可以被修改或者复用
 func (p *BinaryParser) Write(conn *Conn, args ...[]byte) error {
+	msgLen, err := p.checkLen(args)
+	if err != nil {
+		return err
+	}
+
+	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
+	p.putLen(msg, msgLen)
+
+	// write data
+	l := p.lenMsgLen
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	conn.Write(msg)
+
+	return nil
+}
+
+// WriteNoCopy 同Write，但是不复制args，和队列中的其他消息合并之后用writev写入
+// 写入完成之前args不能被修改，也不能归还到缓冲区池
+func (p *BinaryParser) WriteNoCopy(conn *Conn, args ...[]byte) error {
+	msgLen, err := p.checkLen(args)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, p.lenMsgLen)
+	p.putLen(header, msgLen)
+
+	// write data
+	bufs := make(net.Buffers, 0, len(args)+1)
+	bufs = append(bufs, header)
+	for i := 0; i < len(args); i++ {
+		if len(args[i]) > 0 {
+			bufs = append(bufs, args[i])
+		}
+	}
+
+	conn.WriteBuffers(bufs)
+
+	return nil
+}
+
+func (p *BinaryParser) checkLen(args [][]byte) (uint32, error) {
 	// get len
 	var msgLen uint32
 	for i := 0; i < len(args); i++ {
@@ -106,39 +153,29 @@ func (p *BinaryParser) Write(conn *Conn, args ...[]byte) error {
 
 	// check len
 	if msgLen > p.maxMsgLen {
-		return errors.New("message too long")
+		return 0, errors.New("message too long")
 	} else if msgLen < p.minMsgLen {
-		return errors.New("message too short")
+		return 0, errors.New("message too short")
 	}
+	return msgLen, nil
+}
 
-	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
-
-	// write len
+// putLen 把长度写入b的开头
+func (p *BinaryParser) putLen(b []byte, msgLen uint32) {
 	switch p.lenMsgLen {
 	case 1:
-		msg[0] = byte(msgLen)
+		b[0] = byte(msgLen)
 	case 2:
 		if p.littleEndian {
-			binary.LittleEndian.PutUint16(msg, uint16(msgLen))
+			binary.LittleEndian.PutUint16(b, uint16(msgLen))
 		} else {
-			binary.BigEndian.PutUint16(msg, uint16(msgLen))
+			binary.BigEndian.PutUint16(b, uint16(msgLen))
 		}
 	case 4:
 		if p.littleEndian {
-			binary.LittleEndian.PutUint32(msg, msgLen)
+			binary.LittleEndian.PutUint32(b, msgLen)
 		} else {
-			binary.BigEndian.PutUint32(msg, msgLen)
+			binary.BigEndian.PutUint32(b, msgLen)
 		}
 	}
-
-	// write data
-	l := p.lenMsgLen
-	for i := 0; i < len(args); i++ {
-		copy(msg[l:], args[i])
-		l += len(args[i])
-	}
-
-	conn.Write(msg)
-
-	return nil
 }
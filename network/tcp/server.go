@@ -1,6 +1,9 @@
 package tcp
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/YiuTerran/go-common/base/log"
 	"github.com/YiuTerran/go-common/base/structs/set"
 	"github.com/YiuTerran/go-common/network"
@@ -22,20 +25,43 @@ type Server struct {
 
 	// msg parser
 	Parser IParser
+	// TLS 不为nil时使用tls，配置CAFile时校验客户端证书(mTLS)
+	TLS *TLSOptions
+	// Stats 不为nil时统计连接数、流量等，可以用prom.RegisterNetwork导出
+	Stats *network.Stats
 }
 
 func (server *Server) Start() {
-	server.init()
+	if err := server.init(); err != nil {
+		log.Fatal("%v", err)
+	}
+	go server.run()
+}
+
+// Listen 同Start，初始化失败(比如端口被占用)时返回错误而不是退出进程
+func (server *Server) Listen() error {
+	if err := server.init(); err != nil {
+		return err
+	}
 	go server.run()
+	return nil
 }
 
-func (server *Server) init() {
+func (server *Server) init() error {
+	if server.NewSessionFunc == nil {
+		return errors.New("NewSessionFunc must not be nil")
+	}
 	ln, err := net.Listen("tcp", server.Addr)
 	if err != nil {
-		log.Fatal("fail to start tcp server:%v", err)
+		return fmt.Errorf("fail to start tcp server:%w", err)
 	}
-	if server.NewSessionFunc == nil {
-		log.Fatal("NewSessionFunc must not be nil")
+	if server.TLS != nil {
+		cfg, err := server.TLS.ServerConfig()
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("fail to start tls server:%w", err)
+		}
+		ln = tls.NewListener(ln, cfg)
 	}
 
 	server.ln = ln
@@ -45,6 +71,7 @@ func (server *Server) init() {
 	if server.Parser == nil {
 		server.Parser = NewDefaultParser()
 	}
+	return nil
 }
 
 func (server *Server) run() {
@@ -76,6 +103,7 @@ func (server *Server) run() {
 		if server.MaxConnNum > 0 && server.cons.Size() >= server.MaxConnNum {
 			server.mutexCons.Unlock()
 			_ = conn.Close()
+			server.Stats.ConnRejected()
 			log.Warn("too many tcp connections")
 			continue
 		}
@@ -84,23 +112,41 @@ func (server *Server) run() {
 
 		server.wgCons.Add(1)
 
-		tcpConn := newConn(conn, server.Parser)
-		session := server.NewSessionFunc(tcpConn)
 		go func() {
+			defer server.wgCons.Done()
+			//握手在连接自己的协程中进行，避免阻塞accept
+			if server.TLS != nil {
+				if err := handshake(conn, server.TLS.handshakeTimeout()); err != nil {
+					log.Warn("tls handshake with %v failed: %v", conn.RemoteAddr(), err)
+					_ = conn.Close()
+					server.removeConn(conn)
+					server.Stats.ConnRejected()
+					return
+				}
+			}
+			tcpConn := newConn(conn, server.Parser, server.Stats)
+			server.Stats.ConnAccepted(tcpConn)
+			session := server.NewSessionFunc(tcpConn)
 			session.Run()
 
 			// cleanup
 			tcpConn.Close()
-			server.mutexCons.Lock()
-			server.cons.RemoveItem(conn)
-			server.mutexCons.Unlock()
+			server.removeConn(conn)
+			server.Stats.SessionClosed(tcpConn, session)
 			session.OnClose()
-
-			server.wgCons.Done()
 		}()
 	}
 }
 
+func (server *Server) removeConn(conn net.Conn) {
+	server.mutexCons.Lock()
+	//Close之后cons为nil
+	if server.cons != nil {
+		server.cons.RemoveItem(conn)
+	}
+	server.mutexCons.Unlock()
+}
+
 func (server *Server) Close() {
 	_ = server.ln.Close()
 	server.wgLn.Wait()
@@ -0,0 +1,256 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/YiuTerran/go-common/base/log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**  tcp的tls/mTLS支持
+  *  证书可以用文件配置，也可以直接传入*tls.Config；用文件配置时支持热加载，证书文件更新之后新的连接使用新证书
+**/
+
+const (
+	defaultCertReloadInterval = time.Minute
+	defaultHandshakeTimeout   = 10 * time.Second
+)
+
+// TLSOptions tls配置，Server和Client共用
+type TLSOptions struct {
+	// Config 基础配置，可以为nil；设置了CertFile时证书以文件为准
+	Config *tls.Config
+	// CertFile KeyFile 证书和私钥文件，服务端必须配置(或者在Config中设置证书)，客户端配置时作为mTLS的客户端证书
+	CertFile string
+	KeyFile  string
+	// CAFile 服务端用来校验客户端证书，客户端用来校验服务端证书
+	CAFile string
+	// ClientAuth 服务端对客户端证书的要求，优先级高于Config.ClientAuth
+	// 两者都没有设置时，配置了CAFile默认是tls.RequireAndVerifyClientCert
+	ClientAuth tls.ClientAuthType
+	// ServerName 客户端校验的服务端域名，默认使用地址中的host
+	ServerName         string
+	InsecureSkipVerify bool
+	// ReloadInterval 检查证书文件是否变化的最小间隔，默认1分钟，<0表示不检查(仍然可以手动调用Reload)
+	ReloadInterval time.Duration
+	// HandshakeTimeout 握手超时，默认10秒
+	HandshakeTimeout time.Duration
+
+	once     sync.Once
+	reloader *CertReloader
+	err      error
+}
+
+// Reload 立即重新加载证书文件，没有配置证书文件时什么都不做
+func (o *TLSOptions) Reload() error {
+	if err := o.init(); err != nil {
+		return err
+	}
+	if o.reloader == nil {
+		return nil
+	}
+	return o.reloader.Reload()
+}
+
+func (o *TLSOptions) init() error {
+	o.once.Do(func() {
+		if o.CertFile == "" && o.KeyFile == "" {
+			return
+		}
+		interval := o.ReloadInterval
+		if interval == 0 {
+			interval = defaultCertReloadInterval
+		}
+		o.reloader, o.err = NewCertReloader(o.CertFile, o.KeyFile, interval)
+	})
+	return o.err
+}
+
+func (o *TLSOptions) handshakeTimeout() time.Duration {
+	if o.HandshakeTimeout > 0 {
+		return o.HandshakeTimeout
+	}
+	return defaultHandshakeTimeout
+}
+
+func (o *TLSOptions) baseConfig() (*tls.Config, error) {
+	if err := o.init(); err != nil {
+		return nil, err
+	}
+	if o.Config != nil {
+		return o.Config.Clone(), nil
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12}, nil
+}
+
+// ServerConfig 生成服务端的tls配置
+func (o *TLSOptions) ServerConfig() (*tls.Config, error) {
+	cfg, err := o.baseConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o.reloader != nil {
+		cfg.Certificates = nil
+		cfg.GetCertificate = o.reloader.GetCertificate
+	} else if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return nil, errors.New("tls server needs a certificate")
+	}
+	if o.CAFile != "" {
+		if cfg.ClientCAs, err = loadCertPool(o.CAFile); err != nil {
+			return nil, err
+		}
+		//Config中已经设置的ClientAuth保持不变，比如tls.VerifyClientCertIfGiven
+		if cfg.ClientAuth == tls.NoClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if o.ClientAuth != tls.NoClientCert {
+		cfg.ClientAuth = o.ClientAuth
+	}
+	return cfg, nil
+}
+
+// ClientConfig 生成客户端的tls配置，addr用来确定默认的ServerName
+func (o *TLSOptions) ClientConfig(addr string) (*tls.Config, error) {
+	cfg, err := o.baseConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o.reloader != nil {
+		cfg.Certificates = nil
+		cfg.GetClientCertificate = o.reloader.GetClientCertificate
+	}
+	if o.CAFile != "" {
+		if cfg.RootCAs, err = loadCertPool(o.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if o.ServerName != "" {
+		cfg.ServerName = o.ServerName
+	} else if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	if o.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read ca file %s: %w", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in ca file %s", file)
+	}
+	return pool, nil
+}
+
+// CertReloader 证书热加载，握手时发现证书文件变化就重新加载，加载失败时继续使用旧证书
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	cert atomic.Value //*tls.Certificate
+
+	mu      sync.Mutex
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCertReloader interval是检查文件变化的最小间隔，<=0表示只能手动Reload
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 立即重新加载证书
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+// load 需要持有锁
+func (r *CertReloader) load() error {
+	modTime := r.latestModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate %s: %w", r.certFile, err)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	r.checked = time.Now()
+	return nil
+}
+
+func (r *CertReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *CertReloader) maybeReload() {
+	if r.interval <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < r.interval {
+		return
+	}
+	r.checked = time.Now()
+	if !r.latestModTime().After(r.modTime) {
+		return
+	}
+	if err := r.load(); err != nil {
+		log.Error("reload tls certificate failed, keep using the old one: %v", err)
+		return
+	}
+	log.Info("tls certificate reloaded: %s", r.certFile)
+}
+
+// Certificate 当前使用的证书
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.maybeReload()
+	return r.cert.Load().(*tls.Certificate)
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// handshake 带超时的握手，conn不是tls连接时什么都不做
+func handshake(conn net.Conn, timeout time.Duration) error {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	_ = tc.SetDeadline(time.Now().Add(timeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	return tc.SetDeadline(time.Time{})
+}
@@ -0,0 +1,228 @@
+package tcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YiuTerran/go-common/network"
+)
+
+// testCA 测试用的CA，签发的证书写到dir中
+type testCA struct {
+	dir    string
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{dir: t.TempDir(), cert: cert, key: key, serial: 1}
+	writePEM(t, ca.path("ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+// issue 签发证书，写到name.pem和name.key，返回两个文件的路径
+func (ca *testCA) issue(t *testing.T, name, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := ca.path(name+".pem"), ca.path(name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// certSession 记录对端证书之后等待连接关闭
+type certSession struct {
+	conn  *Conn
+	peers chan<- []*x509.Certificate
+}
+
+func (s *certSession) Run() {
+	s.peers <- s.conn.PeerCertificates()
+	_, _ = s.conn.ReadMsg()
+}
+
+func (s *certSession) OnClose() {}
+
+func startTLSServer(t *testing.T, opts *TLSOptions) (*Server, <-chan []*x509.Certificate) {
+	peers := make(chan []*x509.Certificate, 4)
+	server := &Server{
+		Addr: "127.0.0.1:0",
+		TLS:  opts,
+		NewSessionFunc: func(conn *Conn) network.Session {
+			return &certSession{conn: conn, peers: peers}
+		},
+		Stats: network.NewStats(),
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server, peers
+}
+
+// dial 使用客户端配置连接并完成握手，返回服务端证书的CommonName
+func dial(t *testing.T, server *Server, opts *TLSOptions) (*tls.Conn, string) {
+	addr := server.ln.Addr().String()
+	cfg, err := opts.ClientConfig(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestTLS_MutualAuth(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", "server")
+	clientCert, clientKey := ca.issue(t, "client", "device-1")
+	server, peers := startTLSServer(t, &TLSOptions{CertFile: serverCert, KeyFile: serverKey, CAFile: ca.path("ca.pem")})
+
+	//客户端证书可以在session中拿到，用于设备认证
+	_, cn := dial(t, server, &TLSOptions{CertFile: clientCert, KeyFile: clientKey, CAFile: ca.path("ca.pem")})
+	if cn != "server" {
+		t.Fatalf("unexpected server certificate %s", cn)
+	}
+	select {
+	case certs := <-peers:
+		if len(certs) == 0 || certs[0].Subject.CommonName != "device-1" {
+			t.Fatalf("unexpected peer certificates %v", certs)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not created")
+	}
+
+	//没有客户端证书时握手失败，不会创建session
+	conn, _ := dial(t, server, &TLSOptions{CAFile: ca.path("ca.pem")})
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expect handshake failure without client certificate")
+	}
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, rejected, _ := server.Stats.ConnCounts(); rejected == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection without client certificate should be rejected")
+		}
+	}
+	select {
+	case certs := <-peers:
+		t.Fatalf("unexpected session with %v", certs)
+	default:
+	}
+}
+
+func TestTLS_ClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", "server")
+	cases := []struct {
+		opts   *TLSOptions
+		expect tls.ClientAuthType
+	}{
+		{&TLSOptions{CAFile: ca.path("ca.pem")}, tls.RequireAndVerifyClientCert},
+		{&TLSOptions{CAFile: ca.path("ca.pem"), Config: &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven}},
+			tls.VerifyClientCertIfGiven},
+		{&TLSOptions{CAFile: ca.path("ca.pem"), Config: &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven},
+			ClientAuth: tls.RequireAnyClientCert}, tls.RequireAnyClientCert},
+	}
+	for i, c := range cases {
+		c.opts.CertFile, c.opts.KeyFile = certFile, keyFile
+		cfg, err := c.opts.ServerConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ClientAuth != c.expect || cfg.ClientCAs == nil {
+			t.Fatalf("case %d: expect %v, got %v", i, c.expect, cfg.ClientAuth)
+		}
+	}
+}
+
+func TestTLS_CertReload(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", "server-v1")
+	server, _ := startTLSServer(t, &TLSOptions{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Millisecond})
+	client := &TLSOptions{CAFile: ca.path("ca.pem")}
+	if _, cn := dial(t, server, client); cn != "server-v1" {
+		t.Fatalf("unexpected server certificate %s", cn)
+	}
+
+	//轮换证书，修改时间设置到未来，避免文件系统时间精度的影响
+	rotatedCert, rotatedKey := ca.issue(t, "rotated", "server-v2")
+	for _, f := range [][2]string{{rotatedCert, certFile}, {rotatedKey, keyFile}} {
+		if err := os.Rename(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		if err := os.Chtimes(f[1], future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, cn := dial(t, server, client); cn != "server-v2" {
+		t.Fatalf("rotated certificate not used, got %s", cn)
+	}
+}
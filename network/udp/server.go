@@ -25,6 +25,8 @@ type Server struct {
 	Processor network.MsgProcessor
 	//发送失败后尝试次数
 	FailTry int
+	//不为nil时统计流量、反序列化错误等，udp没有连接的统计
+	Stats *network.Stats
 
 	closeSig  chan struct{}
 	readChan  *chanx.UnboundedChan[*MsgInfo]
@@ -76,11 +78,13 @@ func (server *Server) doWrite() {
 		}
 		count := server.FailTry
 		for count >= 0 {
-			_, err := server.conn.WriteTo(b.Msg, b.Addr)
+			n, err := server.conn.WriteTo(b.Msg, b.Addr)
 			if err != nil {
 				log.Error("fail to write udp chan:%+v", err)
 				count--
 			} else {
+				server.Stats.BytesOut(n)
+				server.Stats.MsgOut()
 				break
 			}
 		}
@@ -100,6 +104,7 @@ func (server *Server) doRead() {
 		}
 		msg, err := server.Processor.Unmarshal(b.Msg)
 		if err != nil {
+			server.Stats.ParseError()
 			log.Error("fail to decode udp msg:%v", err)
 			continue
 		}
@@ -138,6 +143,8 @@ func (server *Server) listen() {
 				}
 				continue
 			}
+			server.Stats.BytesIn(n)
+			server.Stats.MsgIn()
 			server.readChan.In <- &MsgInfo{
 				Addr: addr,
 				Msg:  buffer[:n],
@@ -0,0 +1,75 @@
+package prom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+/**  模块队列长度的监控，配合module.GoroutineMixIn使用
+**/
+
+// ModuleStats 模块队列统计，module.GoroutineMixIn实现了这个接口
+type ModuleStats interface {
+	// PendingCallSize 等待回调的异步操作数
+	PendingCallSize() int
+	// ChanCallSize 等待执行的rpc请求数
+	ChanCallSize() int
+	// DispatcherSize 等待执行的定时器数
+	DispatcherSize() int
+}
+
+type moduleCollector struct {
+	mu   sync.RWMutex
+	mods map[string]ModuleStats
+
+	pendingCall *prometheus.Desc
+	chanCall    *prometheus.Desc
+	dispatcher  *prometheus.Desc
+}
+
+var (
+	moduleCollectorOnce    sync.Once
+	defaultModuleCollector = &moduleCollector{
+		mods: make(map[string]ModuleStats),
+		pendingCall: prometheus.NewDesc("module_pending_call_size",
+			"Number of async calls waiting for callback in the module.", []string{"module"}, nil),
+		chanCall: prometheus.NewDesc("module_chan_call_size",
+			"Number of rpc calls queued in the module mailbox.", []string{"module"}, nil),
+		dispatcher: prometheus.NewDesc("module_dispatcher_size",
+			"Number of fired timers waiting to run in the module.", []string{"module"}, nil),
+	}
+)
+
+func (c *moduleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pendingCall
+	ch <- c.chanCall
+	ch <- c.dispatcher
+}
+
+func (c *moduleCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for name, s := range c.mods {
+		ch <- prometheus.MustNewConstMetric(c.pendingCall, prometheus.GaugeValue, float64(s.PendingCallSize()), name)
+		ch <- prometheus.MustNewConstMetric(c.chanCall, prometheus.GaugeValue, float64(s.ChanCallSize()), name)
+		ch <- prometheus.MustNewConstMetric(c.dispatcher, prometheus.GaugeValue, float64(s.DispatcherSize()), name)
+	}
+}
+
+// RegisterModule 把模块的队列长度注册为gauge，以module为label，同名模块会被覆盖
+// 一般在模块的OnInit中调用，OnDestroy中调用UnregisterModule
+func RegisterModule(name string, s ModuleStats) {
+	moduleCollectorOnce.Do(func() {
+		prometheus.MustRegister(defaultModuleCollector)
+	})
+	defaultModuleCollector.mu.Lock()
+	defer defaultModuleCollector.mu.Unlock()
+	defaultModuleCollector.mods[name] = s
+}
+
+// UnregisterModule 取消模块的监控
+func UnregisterModule(name string) {
+	defaultModuleCollector.mu.Lock()
+	defer defaultModuleCollector.mu.Unlock()
+	delete(defaultModuleCollector.mods, name)
+}
@@ -0,0 +1,136 @@
+package prom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+/**  网络服务的连接统计，配合network.Stats使用
+**/
+
+// NetworkStats 网络服务的统计，network.Stats实现了这个接口
+type NetworkStats interface {
+	// ConnCounts 接受、拒绝的连接总数和当前连接数
+	ConnCounts() (accepted, rejected, active int64)
+	// ClosedCounts 关闭原因 -> 关闭的连接数
+	ClosedCounts() map[string]int64
+	// Traffic 收发的字节数和消息数
+	Traffic() (bytesIn, bytesOut, msgsIn, msgsOut int64)
+	// ParseErrors 分包或者反序列化出错的次数
+	ParseErrors() int64
+	// WriteQueueSizes 当前每个连接的写队列长度
+	WriteQueueSizes() []int
+	// ForEachHandler 遍历每个消息id的处理耗时，buckets是上限 -> 累计数量
+	ForEachHandler(f func(id string, count uint64, sum float64, buckets map[float64]uint64))
+}
+
+type networkCollector struct {
+	mu      sync.RWMutex
+	servers map[string]NetworkStats
+
+	accepted    *prometheus.Desc
+	rejected    *prometheus.Desc
+	active      *prometheus.Desc
+	closed      *prometheus.Desc
+	bytesIn     *prometheus.Desc
+	bytesOut    *prometheus.Desc
+	msgsIn      *prometheus.Desc
+	msgsOut     *prometheus.Desc
+	parseErrors *prometheus.Desc
+	queueTotal  *prometheus.Desc
+	queueMax    *prometheus.Desc
+	handler     *prometheus.Desc
+}
+
+func newNetworkDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, append([]string{"server"}, labels...), nil)
+}
+
+var (
+	networkCollectorOnce    sync.Once
+	defaultNetworkCollector = &networkCollector{
+		servers:  make(map[string]NetworkStats),
+		accepted: newNetworkDesc("network_connections_accepted_total", "Number of accepted connections."),
+		rejected: newNetworkDesc("network_connections_rejected_total",
+			"Number of connections rejected by connection limit, handshake or auth."),
+		active: newNetworkDesc("network_connections_active", "Number of open connections."),
+		closed: newNetworkDesc("network_connections_closed_total",
+			"Number of closed connections, partitioned by close reason.", "reason"),
+		bytesIn:     newNetworkDesc("network_received_bytes_total", "Bytes received."),
+		bytesOut:    newNetworkDesc("network_sent_bytes_total", "Bytes sent."),
+		msgsIn:      newNetworkDesc("network_received_messages_total", "Messages received."),
+		msgsOut:     newNetworkDesc("network_sent_messages_total", "Messages sent."),
+		parseErrors: newNetworkDesc("network_parse_errors_total", "Number of framing or unmarshal errors."),
+		queueTotal: newNetworkDesc("network_write_queue_size",
+			"Number of messages waiting to be written, summed over all connections."),
+		queueMax: newNetworkDesc("network_write_queue_max",
+			"Largest write queue among all connections."),
+		handler: newNetworkDesc("network_handler_duration_seconds",
+			"Message handler latencies in seconds, partitioned by message id.", "id"),
+	}
+)
+
+func (c *networkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.accepted
+	ch <- c.rejected
+	ch <- c.active
+	ch <- c.closed
+	ch <- c.bytesIn
+	ch <- c.bytesOut
+	ch <- c.msgsIn
+	ch <- c.msgsOut
+	ch <- c.parseErrors
+	ch <- c.queueTotal
+	ch <- c.queueMax
+	ch <- c.handler
+}
+
+func (c *networkCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for name, s := range c.servers {
+		accepted, rejected, active := s.ConnCounts()
+		ch <- prometheus.MustNewConstMetric(c.accepted, prometheus.CounterValue, float64(accepted), name)
+		ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(rejected), name)
+		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(active), name)
+		for reason, n := range s.ClosedCounts() {
+			ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(n), name, reason)
+		}
+		bytesIn, bytesOut, msgsIn, msgsOut := s.Traffic()
+		ch <- prometheus.MustNewConstMetric(c.bytesIn, prometheus.CounterValue, float64(bytesIn), name)
+		ch <- prometheus.MustNewConstMetric(c.bytesOut, prometheus.CounterValue, float64(bytesOut), name)
+		ch <- prometheus.MustNewConstMetric(c.msgsIn, prometheus.CounterValue, float64(msgsIn), name)
+		ch <- prometheus.MustNewConstMetric(c.msgsOut, prometheus.CounterValue, float64(msgsOut), name)
+		ch <- prometheus.MustNewConstMetric(c.parseErrors, prometheus.CounterValue, float64(s.ParseErrors()), name)
+		var total, max int
+		for _, n := range s.WriteQueueSizes() {
+			total += n
+			if n > max {
+				max = n
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.queueTotal, prometheus.GaugeValue, float64(total), name)
+		ch <- prometheus.MustNewConstMetric(c.queueMax, prometheus.GaugeValue, float64(max), name)
+		s.ForEachHandler(func(id string, count uint64, sum float64, buckets map[float64]uint64) {
+			ch <- prometheus.MustNewConstHistogram(c.handler, count, sum, buckets, name, id)
+		})
+	}
+}
+
+// RegisterNetwork 把网络服务的统计注册给prometheus，以server为label，同名服务会被覆盖
+// 一般在启动服务之前调用，服务关闭之后调用UnregisterNetwork
+func RegisterNetwork(name string, s NetworkStats) {
+	networkCollectorOnce.Do(func() {
+		prometheus.MustRegister(defaultNetworkCollector)
+	})
+	defaultNetworkCollector.mu.Lock()
+	defer defaultNetworkCollector.mu.Unlock()
+	defaultNetworkCollector.servers[name] = s
+}
+
+// UnregisterNetwork 取消网络服务的监控
+func UnregisterNetwork(name string) {
+	defaultNetworkCollector.mu.Lock()
+	defer defaultNetworkCollector.mu.Unlock()
+	delete(defaultNetworkCollector.servers, name)
+}
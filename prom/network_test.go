@@ -0,0 +1,79 @@
+package prom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeNetworkStats struct{}
+
+func (fakeNetworkStats) ConnCounts() (accepted, rejected, active int64) { return 10, 2, 3 }
+func (fakeNetworkStats) ClosedCounts() map[string]int64 {
+	return map[string]int64{"idle timeout": 4, "closed by peer": 1}
+}
+func (fakeNetworkStats) Traffic() (bytesIn, bytesOut, msgsIn, msgsOut int64) { return 100, 200, 5, 6 }
+func (fakeNetworkStats) ParseErrors() int64                                  { return 1 }
+func (fakeNetworkStats) WriteQueueSizes() []int                              { return []int{1, 7, 2} }
+func (fakeNetworkStats) ForEachHandler(f func(id string, count uint64, sum float64, buckets map[float64]uint64)) {
+	f("Login", 3, 0.5, map[float64]uint64{0.01: 1, 0.1: 2, 1: 3})
+}
+
+func TestNetworkCollector(t *testing.T) {
+	RegisterNetwork("gate", fakeNetworkStats{})
+	defer UnregisterNetwork("gate")
+	expect := `
+# HELP network_connections_accepted_total Number of accepted connections.
+# TYPE network_connections_accepted_total counter
+network_connections_accepted_total{server="gate"} 10
+# HELP network_connections_active Number of open connections.
+# TYPE network_connections_active gauge
+network_connections_active{server="gate"} 3
+# HELP network_connections_closed_total Number of closed connections, partitioned by close reason.
+# TYPE network_connections_closed_total counter
+network_connections_closed_total{reason="closed by peer",server="gate"} 1
+network_connections_closed_total{reason="idle timeout",server="gate"} 4
+# HELP network_connections_rejected_total Number of connections rejected by connection limit, handshake or auth.
+# TYPE network_connections_rejected_total counter
+network_connections_rejected_total{server="gate"} 2
+# HELP network_handler_duration_seconds Message handler latencies in seconds, partitioned by message id.
+# TYPE network_handler_duration_seconds histogram
+network_handler_duration_seconds_bucket{id="Login",server="gate",le="0.01"} 1
+network_handler_duration_seconds_bucket{id="Login",server="gate",le="0.1"} 2
+network_handler_duration_seconds_bucket{id="Login",server="gate",le="1"} 3
+network_handler_duration_seconds_bucket{id="Login",server="gate",le="+Inf"} 3
+network_handler_duration_seconds_sum{id="Login",server="gate"} 0.5
+network_handler_duration_seconds_count{id="Login",server="gate"} 3
+# HELP network_parse_errors_total Number of framing or unmarshal errors.
+# TYPE network_parse_errors_total counter
+network_parse_errors_total{server="gate"} 1
+# HELP network_received_bytes_total Bytes received.
+# TYPE network_received_bytes_total counter
+network_received_bytes_total{server="gate"} 100
+# HELP network_received_messages_total Messages received.
+# TYPE network_received_messages_total counter
+network_received_messages_total{server="gate"} 5
+# HELP network_sent_bytes_total Bytes sent.
+# TYPE network_sent_bytes_total counter
+network_sent_bytes_total{server="gate"} 200
+# HELP network_sent_messages_total Messages sent.
+# TYPE network_sent_messages_total counter
+network_sent_messages_total{server="gate"} 6
+# HELP network_write_queue_max Largest write queue among all connections.
+# TYPE network_write_queue_max gauge
+network_write_queue_max{server="gate"} 7
+# HELP network_write_queue_size Number of messages waiting to be written, summed over all connections.
+# TYPE network_write_queue_size gauge
+network_write_queue_size{server="gate"} 10
+`
+	if err := testutil.CollectAndCompare(defaultNetworkCollector, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+
+	//取消之后不再导出
+	UnregisterNetwork("gate")
+	if n := testutil.CollectAndCount(defaultNetworkCollector); n != 0 {
+		t.Fatalf("expect no metrics after unregister, got %d", n)
+	}
+}
@@ -0,0 +1,43 @@
+package redisutil
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"time"
+)
+
+/**  基于redis的租约(分布式锁)
+  *  实现了module.Lease接口，可以用于module.ClusterCron
+**/
+
+type Lease struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewLease prefix会加在所有key的前面，用于区分不同的服务
+func NewLease(client redis.UniversalClient, prefix string) *Lease {
+	return &Lease{client: client, prefix: prefix}
+}
+
+// Acquire SET NX PX，key不存在时占有租约
+func (l *Lease) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	return l.client.SetNX(ctx, l.prefix+key, owner, ttl).Result()
+}
+
+// Renew 延长自己持有的租约，租约已经过期时会重新占有
+// redis的expire精度是秒，ttl会向上取整
+func (l *Lease) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	seconds := int64(math.Ceil(ttl.Seconds()))
+	n, err := ExpireIfEqual.Run(ctx, l.client, []string{l.prefix + key}, owner, seconds).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Release 释放自己持有的租约，别人持有的不会被删除
+func (l *Lease) Release(ctx context.Context, key, owner string) error {
+	return DelIfEqual.Run(ctx, l.client, []string{l.prefix + key}, owner).Err()
+}
@@ -107,7 +107,7 @@ reconnect:
 	client.conns.AddItem(conn)
 	client.Unlock()
 
-	wsConn := newWSConn(conn, client.MaxMsgLen, client.TextFormat)
+	wsConn := newWSConn(conn, client.MaxMsgLen, client.TextFormat, nil)
 	agent := client.NewSessionFunc(wsConn)
 	agent.Run()
 
@@ -22,6 +22,9 @@ type ClientGate struct {
 	RPCServer     rpc.IServer
 	AutoReconnect bool
 	UserData      any
+	IdleTimeout   time.Duration
+	WriteTimeout  time.Duration
+	Heartbeat     *gate.Heartbeat
 }
 
 func (cg *ClientGate) Processor() network.MsgProcessor {
@@ -43,7 +46,8 @@ func (cg *ClientGate) Run(ctx context.Context) {
 			AutoReconnect:    cg.AutoReconnect,
 			TextFormat:       cg.MsgTextFormat,
 			NewSessionFunc: func(conn *Conn) network.Session {
-				a := &gate.SessionAgentImpl{Conn: conn, Gate: cg}
+				a := &gate.SessionAgentImpl{Conn: conn, Gate: cg,
+					IdleTimeout: cg.IdleTimeout, WriteTimeout: cg.WriteTimeout, Heartbeat: cg.Heartbeat}
 				if cg.RPCServer != nil {
 					cg.RPCServer.Go(gate.AgentCreatedEvent, a, cg.UserData)
 				}
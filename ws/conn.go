@@ -6,15 +6,21 @@ package ws
 **/
 import (
 	"errors"
+	"fmt"
 	"github.com/YiuTerran/go-common/base/structs/chanx"
+	"github.com/YiuTerran/go-common/network"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 const (
 	initBufferSize = 2048
+	controlTimeout = time.Second
 )
 
 type Conn struct {
@@ -25,30 +31,57 @@ type Conn struct {
 	closeFlag      bool
 	remoteOriginIP net.Addr
 	userData       any
+	stats          *network.Stats
+
+	readTimeout  int64 //time.Duration
+	writeTimeout int64 //time.Duration
 }
 
 func (wsConn *Conn) UserData() any {
 	return wsConn.userData
 }
 
-func newWSConn(conn *websocket.Conn, maxMsgLen uint32, textFormat bool) *Conn {
+// newWSConn stats为nil时不统计
+func newWSConn(conn *websocket.Conn, maxMsgLen uint32, textFormat bool, stats *network.Stats) *Conn {
 	wsConn := new(Conn)
 	wsConn.conn = conn
+	wsConn.stats = stats
 	wsConn.writeChan = chanx.NewUnboundedChan[[]byte](initBufferSize)
 	wsConn.maxMsgLen = maxMsgLen
 	msgType := websocket.BinaryMessage
 	if textFormat {
 		msgType = websocket.TextMessage
 	}
+	//协议层的ping/pong也算作收到消息，延长读超时
+	conn.SetPingHandler(func(data string) error {
+		wsConn.extendReadDeadline()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(controlTimeout))
+		if err == websocket.ErrCloseSent {
+			return nil
+		} else if e, ok := err.(net.Error); ok && e.Timeout() {
+			return nil
+		}
+		return err
+	})
+	conn.SetPongHandler(func(string) error {
+		wsConn.extendReadDeadline()
+		return nil
+	})
 	go func() {
 		for b := range wsConn.writeChan.Out {
 			if b == nil {
 				break
 			}
+			var deadline time.Time
+			if wt := atomic.LoadInt64(&wsConn.writeTimeout); wt > 0 {
+				deadline = time.Now().Add(time.Duration(wt))
+			}
+			_ = conn.SetWriteDeadline(deadline)
 			err := conn.WriteMessage(msgType, b)
 			if err != nil {
 				break
 			}
+			wsConn.stats.BytesOut(len(b))
 		}
 
 		_ = conn.Close()
@@ -103,10 +136,46 @@ func (wsConn *Conn) RemoteAddr() net.Addr {
 	return wsConn.conn.RemoteAddr()
 }
 
+// SetReadTimeout 每次ReadMsg等待消息的超时，收到ping/pong也会重新计时，0表示不超时
+func (wsConn *Conn) SetReadTimeout(d time.Duration) {
+	atomic.StoreInt64(&wsConn.readTimeout, int64(d))
+	if d <= 0 {
+		_ = wsConn.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// SetWriteTimeout 每次写入的超时，超时之后连接会被关闭，0表示不超时
+func (wsConn *Conn) SetWriteTimeout(d time.Duration) {
+	atomic.StoreInt64(&wsConn.writeTimeout, int64(d))
+}
+
+func (wsConn *Conn) extendReadDeadline() {
+	if rt := atomic.LoadInt64(&wsConn.readTimeout); rt > 0 {
+		_ = wsConn.conn.SetReadDeadline(time.Now().Add(time.Duration(rt)))
+	}
+}
+
 // ReadMsg goroutine not safe
+// 对端发送close帧时返回的错误包装了io.EOF
 func (wsConn *Conn) ReadMsg() ([]byte, error) {
+	wsConn.extendReadDeadline()
 	_, b, err := wsConn.conn.ReadMessage()
-	return b, err
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		err = fmt.Errorf("%w: %v", io.EOF, ce)
+	}
+	if err != nil {
+		wsConn.stats.ReadError(err)
+		return b, err
+	}
+	wsConn.stats.BytesIn(len(b))
+	wsConn.stats.MsgIn()
+	return b, nil
+}
+
+// WriteQueueLen 写队列中等待写入的消息数
+func (wsConn *Conn) WriteQueueLen() int {
+	return wsConn.writeChan.Len()
 }
 
 // WriteMsg args must not be modified by the others goroutines
@@ -130,6 +199,8 @@ func (wsConn *Conn) WriteMsg(args ...[]byte) error {
 		return errors.New("message too short")
 	}
 
+	wsConn.stats.MsgOut()
+
 	// don't copy
 	if len(args) == 1 {
 		wsConn.doWrite(args[0])
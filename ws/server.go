@@ -31,6 +31,8 @@ type Server struct {
 	NewSessionFunc func(*Conn) network.Session
 	AuthFunc       func(*http.Request) (bool, any)
 	TextFormat     bool //纯文本还是二进制
+	//不为nil时统计连接数、流量等，可以用prom.RegisterNetwork导出
+	Stats *network.Stats
 
 	ln      net.Listener
 	handler *handlerDTO
@@ -41,6 +43,7 @@ type handlerDTO struct {
 	authFunc       func(*http.Request) (bool, any)
 	maxMsgLen      uint32
 	newSessionFunc func(*Conn) network.Session
+	stats          *network.Stats
 	upgrader       websocket.Upgrader
 	conns          *set.Set[*websocket.Conn]
 	mutexConns     sync.Mutex
@@ -124,12 +127,14 @@ func (handler *handlerDTO) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	)
 	if handler.authFunc != nil {
 		if ok, userData = handler.authFunc(r); !ok {
+			handler.stats.ConnRejected()
 			http.Error(w, "Forbidden", 403)
 			return
 		}
 	}
 	conn, err := handler.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		handler.stats.ConnRejected()
 		log.Debug("upgrade error: %v", err)
 		return
 	}
@@ -142,14 +147,16 @@ func (handler *handlerDTO) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if handler.conns == nil {
 		handler.mutexConns.Unlock()
 		_ = conn.Close()
+		handler.stats.ConnRejected()
 		return
 	}
 	handler.conns.AddItem(conn)
 	handler.mutexConns.Unlock()
 
-	wsConn := newWSConn(conn, handler.maxMsgLen, handler.textFormat)
+	wsConn := newWSConn(conn, handler.maxMsgLen, handler.textFormat, handler.stats)
 	wsConn.remoteOriginIP = getRealIP(r)
 	wsConn.userData = userData
+	handler.stats.ConnAccepted(wsConn)
 	session := handler.newSessionFunc(wsConn)
 	session.Run()
 
@@ -158,6 +165,7 @@ func (handler *handlerDTO) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.mutexConns.Lock()
 	handler.conns.RemoveItem(conn)
 	handler.mutexConns.Unlock()
+	handler.stats.SessionClosed(wsConn, session)
 	session.OnClose()
 }
 
@@ -196,6 +204,7 @@ func (server *Server) Start() {
 	server.ln = ln
 	server.handler = &handlerDTO{
 		textFormat:     server.TextFormat,
+		stats:          server.Stats,
 		authFunc:       server.AuthFunc,
 		maxMsgLen:      server.MaxMsgLen,
 		newSessionFunc: server.NewSessionFunc,
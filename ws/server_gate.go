@@ -26,6 +26,14 @@ type ServerGate struct {
 	HTTPTimeout time.Duration
 	CertFile    string
 	KeyFile     string
+
+	//超过这个时间没有收到消息(包括ping/pong)就断开连接，0表示不检查
+	IdleTimeout  time.Duration
+	WriteTimeout time.Duration
+	//应用层心跳，可以为nil
+	Heartbeat *gate.Heartbeat
+	//不为nil时统计连接数、流量等，可以用prom.RegisterNetwork导出
+	Stats *network.Stats
 }
 
 func (sg *ServerGate) Processor() network.MsgProcessor {
@@ -47,8 +55,10 @@ func (sg *ServerGate) Run(ctx context.Context) {
 		wsServer.HTTPTimeout = sg.HTTPTimeout
 		wsServer.CertFile = sg.CertFile
 		wsServer.KeyFile = sg.KeyFile
+		wsServer.Stats = sg.Stats
 		wsServer.NewSessionFunc = func(conn *Conn) network.Session {
-			a := &gate.SessionAgentImpl{Conn: conn, Gate: sg, Data: conn.UserData()}
+			a := &gate.SessionAgentImpl{Conn: conn, Gate: sg, Data: conn.UserData(),
+				IdleTimeout: sg.IdleTimeout, WriteTimeout: sg.WriteTimeout, Heartbeat: sg.Heartbeat, Stats: sg.Stats}
 			if sg.RPCServer != nil {
 				sg.RPCServer.Go(gate.AgentCreatedEvent, a)
 			}